@@ -1,8 +1,7 @@
 package cmd
 
 import (
-	"fmt"
-	"strings"
+	"os"
 
 	log "github.com/cihub/seelog"
 	"github.com/qarth/whattle/optimization"
@@ -17,42 +16,46 @@ var graphCmd = &cobra.Command{
 	Long:  `Graph outputs the DIMACs format graph for the maxflow/mincut problem. upit is a CLI program to optimise an ore reserves ultimate pit limit during feasibility study phase. This application is a tool to generate the needed precedence files to find the ultimate pit.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		runGraph(cmd, args)
-		fmt.Println("graph called")
 	},
 }
 
 func init() {
-	fmt.Println("graph init")
+	RootCmd.AddCommand(graphCmd)
+	flagset := graphCmd.PersistentFlags()
+	flagset.StringP("input", "i", "", "The input file")
+	flagset.StringP("output", "o", "", "The output DIMACS graph file")
+	flagset.StringP("log", "l", "", "Log information to a file")
+	flagset.StringP("params", "p", "", "Grid parameter json file")
+	flagset.IntP("realization", "r", 0, "The realization to write")
 }
 
 func runGraph(cmd *cobra.Command, args []string) {
-	viper.BindPFlags(cmd.Flags())
 
+	viper.BindPFlags(cmd.Flags())
 	logfile := viper.GetString("log")
 	infile := viper.GetString("input")
 	outfile := viper.GetString("output")
 	jsonFile := viper.GetString("params")
-	outputDest := "<console/>"
+	realization := viper.GetInt("realization")
 
 	if len(infile) == 0 || len(outfile) == 0 || len(jsonFile) == 0 {
 		cmd.Usage()
 		return
 	}
 
-	if len(logfile) > 0 {
-		outputDest = fmt.Sprintf(log_file_tmpl, logfile)
-	}
-	log_cfg := strings.Replace(log_cfg_tmpl, log_out_dest, outputDest, -1)
-	logger, _ := log.LoggerFromConfigAsString(log_cfg)
+	initLogger(logfile)
 
-	if logger != nil {
-		log.ReplaceLogger(logger)
+	param := optimization.RunCtx{
+		InputFile:   infile,
+		OutputFile:  outfile,
+		ParamFile:   jsonFile,
+		Realization: realization,
 	}
 
-	param := optimization.RunCtx{
-		InputFile:  infile,
-		OutputFile: outfile,
-		ParamFile:  jsonFile,
+	e := optimization.ExportGraph(param)
+	log.Flush()
+
+	if e != nil {
+		os.Exit(1)
 	}
-	optimization.StartRead(param)
 }
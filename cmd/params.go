@@ -10,14 +10,25 @@ const (
 	default_params = `
 {
 // input
-//   1 (GEOEAS (GSLIB) format grid file. Pre-calculated EBV)
+//   1 (GEOEAS (GSLIB) format grid file, plain or gzipped. Pre-calculated EBV)
 //     grid (The grid definition)
 //       min_x, min_y, min_z (The lower left centroid)
 //       num_x, num_y, num_z (The number of blocks)
 //       siz_x, siz_y, siz_z (The size of a block)
-//     ebv_column (Economic block value column, 1 indexed)
+//     ebv_column (Economic block value column, 1 indexed or by name)
+//     density_column (Optional density column, 1 indexed or by name)
+//     density (Density when there is no column, tonnage is density x volume)
+//     zone_column (Optional geotechnical zone column, 1 indexed or by name)
+//     grade_column (Instead of ebv_column, grades valued by the economics)
+//     grade_columns (Or a list of them, one per element of the economics)
+//     rock_column (Optional rock type column, for the economics)
 //   2 (GZIP .gz file, only ebv, one column, no header)
 //     grid (as above)
+//   3 (MineLib UPIT problem .upit, with its explicit precedence)
+//     prec_file (The .prec file, defaults to the .upit path with .prec)
+//   topography, mined_out (Types 1 and 2, optional surfaces, ESRI ASCII .asc
+//     or gridded x y z points. Blocks whose centroid is above either are air,
+//     with no value and never mined)
 \"input\" : {
   \"type\" : 1,
 
@@ -33,6 +44,30 @@ const (
 //   1 (Benches)
 //     slope (The slope (in degrees))
 //     benches (The number of benches)
+//   2 (Explicit, from a file or loaded along with a MineLib input)
+//     file (Arc file over the grid, plain or gzipped)
+//     format (prec: block, number of predecessors and predecessors, as grid
+//       indexes; csv: ix,iy,iz,pix,piy,piz a block and a predecessor, 0
+//       indexed. Defaults to csv for .csv files, prec otherwise. Arcs of a
+//       block to itself and cycles are rejected)
+//   3 (Benches with slope sectors)
+//     sectors (List of azimuth (degrees clockwise from north) and slope,
+//       interpolated between sectors)
+//     benches (The number of benches)
+//   4 (Benches with slopes by geotechnical zone, of the block above)
+//     zones (List of zone and its slope or sectors)
+//     slope or sectors (For blocks in no listed zone)
+//     benches (The number of benches)
+//   5 (1:5 pattern, the block above and its 4 neighbours)
+//   6 (1:9 pattern, the block above and its 8 neighbours)
+//   7 (Knight's move, 1:5 and 1:9 on alternate benches)
+//   8 (Benches with slopes by elevation, of the bench above)
+//     profile (List of min_z, max_z (bench centroid elevations, max_z
+//       excluded) and the slope or sectors of the band)
+//     slope or sectors (For benches in no band)
+//     benches (The number of benches)
+//   minimal (Methods 1, 3, 4 and 8, drop the arcs implied by others, the same
+//     pits with a smaller graph)
 \"precedence\" : {
   \"method\" : 1,
 
@@ -42,11 +77,73 @@ const (
 
 // optimization_engine
 //   1 (Lerchs Grossmann)
-//   2 (Dimacs program)
-//     dimacs_path (Path to engine)
+//   2 (Pseudoflow, or a Dimacs program)
+//     lowest_label (Process the strong roots of lowest label first, highest
+//       otherwise)
+//     fifo_buckets (Roots of a label in first in first out order, last in
+//       first out otherwise)
+//     dimacs_path (Path to an external max flow program, the pseudoflow in
+//       process if empty)
+//     dimacs_args (Its arguments, {input} and {output} are replaced by the
+//       graph and solution files, otherwise stdin and stdout are used)
+//     timeout (Seconds before the program and its children are killed, 0
+//       for none)
+//     precision (Multiplier of the block values to integer capacities)
+//   3 (Push-relabel, highest label)
+//     precision (As for 2)
+//   4 (Boykov-Kolmogorov augmenting paths)
+//     precision (As for 2)
+//   verify (Check every pit is closed and, for the max flow engines, that
+//     the capacity of its cut is the max flow, which proves it optimal. A
+//     failure stops the run, its blocks are logged as ix,iy,iz)
+//   compare (Engines solving every problem again, their pits compared with
+//     the engine's, which is kept. A difference in value or blocks is logged
+//     as a warning, with the blocks as ix,iy,iz)
 \"optimization\" : {
   \"engine\" : 1
 }
+
+// shells (Optional nested pit shells, the output is the shell of each block)
+//   revenue_factors (The factors applied to the positive block values)
+//   or min_factor, max_factor, step (The range of factors)
+
+// report (Optional pit by pit report of the shells, ore is positive value)
+//   file (The csv file)
+//   mining_rate (Tonnes per year)
+//   discount_rate (Per year, 0.1 is 10%)
+
+// economics (Optional block values from the grade_column, mill or waste.
+//     The metal in the final pits is reported)
+//   price, selling_cost (Per unit of product)
+//   grade_factor (Units of product per tonne for a unit of grade, 1 if 0)
+//   recovery, mining_cost, processing_cost (Costs per tonne)
+//   elements (Instead of price to recovery, list of name, unit, price,
+//     selling_cost, grade_factor and recovery, one per grade_columns)
+//   rocks (List of rock and its recovery, mining_cost, processing_cost and
+//     density, used when there is no density_column. recoveries, one per
+//     element, replaces recovery. The recovery and costs left out are the
+//     global ones)
+//   mcaf (Optional mining cost adjustment factors, also applied to an
+//     ebv_column input when there are no prices. Needs a mining_cost)
+//     reference ("elevation" of the bench, the default, or "depth" below
+//       the topography)
+//     table (List of level and factor, interpolated between the levels)
+//   value_file (Optional GSLIB output of the block values and their mcaf)
+
+// constraints (Optional regions forced in or out of the pit. An excluded
+//     block is never mined, nor are the blocks below depending on it. An
+//     included block is mined with the blocks it depends on)
+//   include, exclude (Lists of regions, the union of)
+//     name (For the log)
+//     blocks (List of ix, iy, iz, 0 indexed)
+//     file (csv file of ix,iy,iz lines)
+//     box (ix, iy, iz from and to, inclusive)
+//     polygon (List of x, y in plan, the blocks whose centroid is inside)
+//     min_z, max_z (Elevation limits of the polygon, when max_z > min_z)
+//     outside (Take the blocks outside the polygon instead)
+//   lease (Optional lease boundary, list of x, y in plan in the grid
+//     coordinates. The blocks whose centroid is outside are excluded, the
+//     pit is solved again without it to report the value lost)
 }`
 )
 
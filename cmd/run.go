@@ -50,22 +50,12 @@ func runOpt(cmd *cobra.Command, args []string) {
 	outfile := viper.GetString("output")
 	jsonFile := viper.GetString("params")
 
-	outputDest := "<console/>"
-
 	if len(infile) == 0 || len(outfile) == 0 || len(jsonFile) == 0 {
 		cmd.Usage()
 		return
 	}
 
-	if len(logfile) > 0 {
-		outputDest = fmt.Sprintf(log_file_tmpl, logfile)
-	}
-	log_cfg := strings.Replace(log_cfg_tmpl, log_out_dest, outputDest, -1)
-	logger, _ := log.LoggerFromConfigAsString(log_cfg)
-
-	if logger != nil {
-		log.ReplaceLogger(logger)
-	}
+	initLogger(logfile)
 
 	param := optimization.RunCtx{
 		InputFile:  infile,
@@ -78,3 +68,19 @@ func runOpt(cmd *cobra.Command, args []string) {
 	log.Info(fmt.Printf("%s %s complete\n", PROGRAM_NAME, PROGRAM_VERSION))
 	log.Flush()
 }
+
+// Log to the console, or to a rolling file if given
+func initLogger(logfile string) {
+
+	outputDest := "<console/>"
+
+	if len(logfile) > 0 {
+		outputDest = fmt.Sprintf(log_file_tmpl, logfile)
+	}
+	log_cfg := strings.Replace(log_cfg_tmpl, log_out_dest, outputDest, -1)
+	logger, _ := log.LoggerFromConfigAsString(log_cfg)
+
+	if logger != nil {
+		log.ReplaceLogger(logger)
+	}
+}
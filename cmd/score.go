@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/cihub/seelog"
+	"github.com/qarth/whattle/optimization"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// scoreCmd represents the score command
+var scoreCmd = &cobra.Command{
+	Use:   "score",
+	Short: "score a MineLib solution",
+	Long:  "check a MineLib .sol solution respects the precedence and report its objective value",
+	Run: func(cmd *cobra.Command, args []string) {
+		runScore(cmd, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(scoreCmd)
+	flagset := scoreCmd.PersistentFlags()
+	flagset.StringP("input", "i", "", "The input file")
+	flagset.StringP("solution", "s", "", "The solution (.sol) file")
+	flagset.StringP("log", "l", "", "Log information to a file")
+	flagset.StringP("params", "p", "", "Grid parameter json file")
+}
+
+func runScore(cmd *cobra.Command, args []string) {
+
+	viper.BindPFlags(cmd.Flags())
+	logfile := viper.GetString("log")
+	infile := viper.GetString("input")
+	solfile := viper.GetString("solution")
+	jsonFile := viper.GetString("params")
+
+	if len(infile) == 0 || len(solfile) == 0 || len(jsonFile) == 0 {
+		cmd.Usage()
+		return
+	}
+
+	initLogger(logfile)
+
+	param := optimization.RunCtx{
+		InputFile:    infile,
+		ParamFile:    jsonFile,
+		SolutionFile: solfile,
+	}
+
+	e := optimization.Score(param)
+	log.Flush()
+
+	if e != nil {
+		os.Exit(1)
+	}
+}
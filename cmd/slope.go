@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/cihub/seelog"
+	"github.com/qarth/whattle/optimization"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// slopeCmd represents the slope command
+var slopeCmd = &cobra.Command{
+	Use:   "slope",
+	Short: "report the slope accuracy of the precedence template",
+	Long:  "measure how far the pit walls implied by the bench precedence template are from the target slopes, at every azimuth and depth, to choose num_benches",
+	Run: func(cmd *cobra.Command, args []string) {
+		runSlope(cmd, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(slopeCmd)
+	flagset := slopeCmd.PersistentFlags()
+	flagset.StringP("output", "o", "", "The output csv file")
+	flagset.StringP("log", "l", "", "Log information to a file")
+	flagset.StringP("params", "p", "", "Grid parameter json file")
+	flagset.IntSliceP("benches", "b", nil, "The num_benches to compare, the params one if none")
+	flagset.IntP("depth", "d", 0, "The depth in benches, the grid height if 0")
+	flagset.Float64P("step", "s", optimization.DEFAULT_AZIMUTH_STEP, "The azimuth step in degrees")
+}
+
+func runSlope(cmd *cobra.Command, args []string) {
+
+	viper.BindPFlags(cmd.Flags())
+	logfile := viper.GetString("log")
+	outfile := viper.GetString("output")
+	jsonFile := viper.GetString("params")
+	depth := viper.GetInt("depth")
+	step := viper.GetFloat64("step")
+	benches, _ := cmd.Flags().GetIntSlice("benches")
+
+	if len(jsonFile) == 0 {
+		cmd.Usage()
+		return
+	}
+
+	initLogger(logfile)
+
+	param := optimization.RunCtx{
+		OutputFile: outfile,
+		ParamFile:  jsonFile,
+	}
+
+	e := optimization.SlopeReport(param, benches, depth, step)
+	log.Flush()
+
+	if e != nil {
+		os.Exit(1)
+	}
+}
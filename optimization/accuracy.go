@@ -0,0 +1,339 @@
+package optimization
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+
+	log "github.com/cihub/seelog"
+)
+
+// The template only holds num_benches benches, deeper walls come from
+// chaining it. The accuracy report follows the cone of a single block,
+// the closure of the template, up to some depth and compares its wall with
+// the true cone along every azimuth. The wall at a depth is the centroid of
+// the last block of the cone on the ray from the apex, the ideal wall the
+// same for the blocks whose centroid is inside the true cone, which is the
+// best any template can do on the grid.
+
+const (
+	DEFAULT_AZIMUTH_STEP = 15.0
+)
+
+type (
+	// SlopeAccuracy is a line of the slope accuracy report. The angles are
+	// in degrees, a positive error is a wall steeper than the target.
+	SlopeAccuracy struct {
+		Domain    string
+		Benches   int
+		Depth     int
+		Azimuth   float64
+		Target    float64
+		Ideal     float64
+		Effective float64
+	}
+
+	// The slopes of a part of the model sharing a template
+	slopeDomain struct {
+		name  string
+		table SlopeSectors
+	}
+)
+
+func (row *SlopeAccuracy) error() float64 {
+	return row.Effective - row.Target
+}
+
+// The error of the template against the ideal wall, which is all a template
+// can be blamed for
+func (row *SlopeAccuracy) templateError() float64 {
+	return row.Effective - row.Ideal
+}
+
+// Is the ideal wall vertical, the true cone holding no block but the apex at
+// this depth. Every template is wrong there by the same angle.
+func (row *SlopeAccuracy) degenerate() bool {
+	return row.Ideal >= 90.0
+}
+
+// The slope domains of the bench methods
+func (prec *Precedence) slopeDomains() ([]slopeDomain, error) {
+
+	if prec.NumBenches < MIN_BENCHES || prec.NumBenches > MAX_BENCHES {
+		return nil, fmt.Errorf(
+			"ERROR: benches must be between %v and %v. Supplied: %v",
+			MIN_BENCHES, MAX_BENCHES, prec.NumBenches,
+		)
+	}
+
+	var domains []slopeDomain
+
+	switch prec.Method {
+	case BENCH:
+		domains = append(domains, slopeDomain{"all", constantSlope(prec.Slope)})
+	case SECTORS:
+		domains = append(domains, slopeDomain{"all", prec.Sectors})
+	case ZONES:
+		for i := range prec.Zones {
+			domains = append(domains, slopeDomain{fmt.Sprintf("zone %v", prec.Zones[i].Zone), prec.Zones[i].table()})
+		}
+		domains = append(domains, slopeDomain{"default", prec.defaultTable()})
+	case ELEVATION:
+		for i := range prec.Profile {
+			domains = append(domains, slopeDomain{fmt.Sprintf("band %v", &prec.Profile[i]), prec.Profile[i].table()})
+		}
+		domains = append(domains, slopeDomain{"default", prec.defaultTable()})
+	default:
+		if _, ok := methodNames[prec.Method]; !ok {
+			return nil, methodError(prec.Method)
+		}
+		return nil, fmt.Errorf("ERROR: slope accuracy needs a bench precedence method, not %v", methodNames[prec.Method])
+	}
+
+	for _, d := range domains {
+		if e := d.table.check(); e != nil {
+			return nil, fmt.Errorf("%v (%v)", e, d.name)
+		}
+	}
+
+	return domains, nil
+}
+
+// Measure the template of a domain down to depth benches, every step
+// degrees of azimuth.
+func (prec *Precedence) templateAccuracy(pg *Grid, d slopeDomain, depth int, step float64) []SlopeAccuracy {
+
+	tmpl := prec.benchTemplate(pg, d.table.flattest(), d.table.slopeAt)
+
+	// Half width of the virtual grid, large enough for the true cone
+	theta := d.table.flattest() * math.Pi / 180.0
+	radius := float64(depth) * pg.SizZ / math.Tan(theta)
+	hx := int(radius/pg.SizX) + 2
+	hy := int(radius/pg.SizY) + 2
+	wx := 2*hx + 1
+	wy := 2*hy + 1
+
+	// The cone of the apex, bench by bench upwards
+	cone := make([][]bool, depth+1)
+	for z := range cone {
+		cone[z] = make([]bool, wx*wy)
+	}
+	cone[0][hy*wx+hx] = true
+
+	for z := 0; z < depth; z++ {
+		for k, v := range cone[z] {
+			if !v {
+				continue
+			}
+			x, y := k%wx, k/wx
+			for _, off := range tmpl {
+				nx, ny, nz := x+off[0], y+off[1], z+off[2]
+				if nz <= depth && nx >= 0 && nx < wx && ny >= 0 && ny < wy {
+					cone[nz][ny*wx+nx] = true
+				}
+			}
+		}
+	}
+
+	// The wall along a ray, the distance to the last block in the cone
+	sample := math.Min(pg.SizX, pg.SizY) / 4.0
+	wall := func(az float64, in func(x, y int) bool) float64 {
+		sin, cos := math.Sin(az*math.Pi/180.0), math.Cos(az*math.Pi/180.0)
+		var last float64
+		for t := sample; ; t += sample {
+			x := int(math.Floor(t*sin/pg.SizX + 0.5))
+			y := int(math.Floor(t*cos/pg.SizY + 0.5))
+			if x < -hx || x > hx || y < -hy || y > hy || !in(x, y) {
+				return last
+			}
+			last = math.Hypot(float64(x)*pg.SizX, float64(y)*pg.SizY)
+		}
+	}
+	angle := func(dz, r float64) float64 {
+		return math.Atan2(dz, r) * 180.0 / math.Pi
+	}
+
+	var rows []SlopeAccuracy
+
+	for z := 1; z <= depth; z++ {
+
+		dz := float64(z) * pg.SizZ
+		level := cone[z]
+
+		for az := 0.0; az < 360.0-1e-9; az += step {
+
+			effective := wall(az, func(x, y int) bool {
+				return level[(y+hy)*wx+x+hx]
+			})
+			ideal := wall(az, func(x, y int) bool {
+				xloc, yloc := float64(x)*pg.SizX, float64(y)*pg.SizY
+				rad := dz / math.Tan(d.table.slopeAt(azimuth(xloc, yloc))*math.Pi/180.0)
+				return xloc*xloc+yloc*yloc <= rad*rad
+			})
+
+			rows = append(rows, SlopeAccuracy{
+				Domain:    d.name,
+				Benches:   prec.NumBenches,
+				Depth:     z,
+				Azimuth:   az,
+				Target:    d.table.slopeAt(az),
+				Ideal:     angle(dz, ideal),
+				Effective: angle(dz, effective),
+			})
+		}
+	}
+
+	return rows
+}
+
+// SlopeReport measures how far the walls implied by the precedence template
+// are from the target slopes, for the num_benches of the params or for each
+// of benches. depth is in benches, the grid height if 0. The rows are
+// written as csv to opt.OutputFile if given.
+func SlopeReport(opt RunCtx, benches []int, depth int, step float64) error {
+
+	log.Infof("Begin parsing parameters from %v", opt.ParamFile)
+
+	var params Parameters
+
+	if e := readJSONFile(opt.ParamFile, &params); e != nil {
+		return e
+	}
+
+	prec := &params.Precedence
+	pg := &params.Input.Grid
+
+	if len(benches) == 0 {
+		benches = []int{prec.NumBenches}
+	}
+	if depth <= 0 {
+		depth = pg.NumZ - 1
+	}
+	if step <= 0 {
+		step = DEFAULT_AZIMUTH_STEP
+	}
+
+	if params.Input.Type == MINELIB {
+		e := fmt.Errorf("ERROR: slope accuracy needs a block grid")
+		log.Error(e)
+		return e
+	} else if pg.NumZ < 1 || pg.SizX <= 0 || pg.SizY <= 0 || pg.SizZ <= 0 {
+		e := fmt.Errorf("ERROR: slope accuracy needs a grid. Supplied: %v", pg)
+		log.Error(e)
+		return e
+	} else if depth < 1 {
+		e := fmt.Errorf("ERROR: depth must be at least 1 bench. Supplied: %v", depth)
+		log.Error(e)
+		return e
+	}
+
+	var rows []SlopeAccuracy
+
+	for _, n := range benches {
+
+		prec.NumBenches = n
+
+		domains, e := prec.slopeDomains()
+		if e != nil {
+			log.Error(e)
+			return e
+		}
+
+		for _, d := range domains {
+			log.Infof("Measuring %v benches, %v", n, d.name)
+			rows = append(rows, prec.templateAccuracy(pg, d, depth, step)...)
+		}
+	}
+
+	logSlopeAccuracy(rows)
+
+	if len(opt.OutputFile) > 0 {
+		return writeSlopeAccuracy(opt.OutputFile, rows)
+	}
+
+	return nil
+}
+
+// Log the worst errors of every depth, and of every domain and benches the
+// worst against the ideal wall, so that templates can be compared
+func logSlopeAccuracy(rows []SlopeAccuracy) {
+
+	log.Info("Slope accuracy, errors in degrees (positive is steeper than the target)")
+	log.Info("  domain       benches depth     min     max   ideal min     max")
+
+	for i := 0; i < len(rows); {
+
+		// The errors of a depth, the ideal ones and the worst of all depths
+		under, over := math.Inf(1), math.Inf(-1)
+		iunder, iover := math.Inf(1), math.Inf(-1)
+		worstUnder, worstOver := math.Inf(1), math.Inf(-1)
+
+		j := i
+		for ; j < len(rows) && rows[j].Domain == rows[i].Domain && rows[j].Benches == rows[i].Benches; j++ {
+
+			row := &rows[j]
+			under, over = math.Min(under, row.error()), math.Max(over, row.error())
+			iunder, iover = math.Min(iunder, row.Ideal-row.Target), math.Max(iover, row.Ideal-row.Target)
+
+			if !row.degenerate() {
+				worstUnder = math.Min(worstUnder, row.templateError())
+				worstOver = math.Max(worstOver, row.templateError())
+			}
+
+			if j+1 == len(rows) || rows[j+1].Depth != row.Depth || rows[j+1].Domain != row.Domain || rows[j+1].Benches != row.Benches {
+				log.Infof(
+					"  %-12v %7d %5d %7.2f %7.2f %11.2f %7.2f",
+					row.Domain, row.Benches, row.Depth, under, over, iunder, iover,
+				)
+				under, over = math.Inf(1), math.Inf(-1)
+				iunder, iover = math.Inf(1), math.Inf(-1)
+			}
+		}
+
+		if math.IsInf(worstUnder, 1) {
+			log.Infof("  %v, %v benches: the ideal walls are all vertical", rows[i].Domain, rows[i].Benches)
+		} else {
+			log.Infof(
+				"  %v, %v benches: worst under-steepening %.2f, over-steepening %.2f, against the ideal wall",
+				rows[i].Domain, rows[i].Benches, math.Max(0, -worstUnder), math.Max(0, worstOver),
+			)
+		}
+
+		i = j
+	}
+}
+
+func writeSlopeAccuracy(outfile string, rows []SlopeAccuracy) error {
+
+	file, e := os.Create(outfile)
+	if e != nil {
+		e = fmt.Errorf("Failed to create output file %v: %v", outfile, e)
+		log.Error(e)
+		return e
+	}
+
+	w := bufio.NewWriter(file)
+
+	fmt.Fprintln(w, "domain,benches,depth,azimuth,target,ideal,effective,error")
+
+	for _, row := range rows {
+		fmt.Fprintf(
+			w, "%v,%d,%d,%g,%g,%g,%g,%g\n",
+			row.Domain, row.Benches, row.Depth, row.Azimuth,
+			row.Target, row.Ideal, row.Effective, row.error(),
+		)
+	}
+
+	e = w.Flush()
+	if ce := file.Close(); e == nil {
+		e = ce
+	}
+
+	if e != nil {
+		e = fmt.Errorf("Failed to write output file %v: %v", outfile, e)
+		log.Error(e)
+	}
+
+	return e
+}
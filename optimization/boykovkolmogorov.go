@@ -0,0 +1,534 @@
+package optimization
+
+import (
+	"math"
+
+	log "github.com/cihub/seelog"
+)
+
+// Boykov and Kolmogorov's augmenting paths on the closure graph. Two search
+// trees grow, one from the source through the positive blocks and one from
+// the sink through the negative ones, until they touch; the path is then
+// augmented and the nodes cut off from their tree by a saturated arc are
+// adopted by another node of the tree, or freed. The trees are kept from one
+// path to the next, which pays on the short regular arcs of a block model.
+//
+// The out arcs are never listed: those of a block are the offsets of its
+// key, the arc number being its first plus the position of the offset. The
+// in arcs are found by trying every offset of the precedence backwards.
+// Only when there are too many offsets for that, as when the blocks left
+// out of a condensed problem shift them, are the in arcs listed. The
+// precedence arcs are infinite, block to predecessor, so their residual
+// capacity is infinite forward and their flow backwards.
+
+const (
+	BK_FREE = 0
+	BK_S    = 1
+	BK_T    = -1
+
+	BK_TERMINAL = -2
+	BK_ORPHAN   = -3
+
+	// The most offsets tried backwards for the in arcs
+	BK_MAX_OFFSETS = 256
+)
+
+type (
+	// BoykovKolmogorov solves the closure graph as a max flow by augmenting
+	// paths between a source and a sink search tree
+	BoykovKolmogorov struct {
+		Precision float64
+		// The max flow of the last solve, in capacity units
+		flow int64
+	}
+
+	boykovKolmogorov struct {
+		numNodes int
+		keys     []int
+		defs     [][]int
+
+		// First arc of every block, its flow by arc, and the residual
+		// capacity to the source if positive, to the sink if negative
+		first []int32
+		flow  []int64
+		tr    []int64
+
+		// The offsets of the precedence and their position in every def,
+		// -1 if not there, or the in arcs listed by block
+		offsets  []int
+		position [][]int32
+		inFirst  []int32
+		inArcs   []int32
+		inTails  []int32
+
+		tree      []int8
+		parent    []int32
+		parentArc []int32
+		// Is the arc to the parent an out arc of the node
+		parentOut []bool
+
+		nextActive  []int32
+		isActive    []bool
+		firstActive int32
+		lastActive  int32
+		orphans     []int32
+
+		// Distance to the terminal, valid when stamped with the clock
+		stamp []int32
+		dist  []int32
+		clock int32
+
+		total    int64
+		augments int
+		adopted  int
+		freed    int
+	}
+)
+
+func newBoykovKolmogorovEngine(param *ConfigParams) UEngine {
+	return &BoykovKolmogorov{Precision: param.precision()}
+}
+
+func (solver *BoykovKolmogorov) computeSolution(ch chan<- string, data []float64, pre *Precedence) ([]bool, int) {
+
+	notifyStatus(ch, "Solving Boykov-Kolmogorov")
+
+	bk := newBoykovKolmogorov(data, pre, solver.Precision)
+	bk.solve()
+
+	solver.flow = bk.total
+
+	return bk.sourceSet(), 0
+}
+
+func (solver *BoykovKolmogorov) maxFlow() (int64, float64, bool) {
+	return solver.flow, solver.Precision, true
+}
+
+func newBoykovKolmogorov(data []float64, pre *Precedence, precision float64) *boykovKolmogorov {
+
+	n := len(data)
+
+	bk := &boykovKolmogorov{
+		numNodes:    n,
+		keys:        pre.keys,
+		defs:        pre.defs,
+		first:       make([]int32, n+1),
+		tr:          make([]int64, n),
+		tree:        make([]int8, n),
+		parent:      make([]int32, n),
+		parentArc:   make([]int32, n),
+		parentOut:   make([]bool, n),
+		nextActive:  make([]int32, n),
+		isActive:    make([]bool, n),
+		firstActive: NOTHING,
+		lastActive:  NOTHING,
+		stamp:       make([]int32, n),
+		dist:        make([]int32, n),
+	}
+
+	for i := 0; i < n; i++ {
+		bk.first[i+1] = bk.first[i]
+		if key := bk.keys[i]; key != MISSING {
+			bk.first[i+1] += int32(len(bk.defs[key]))
+		}
+	}
+	m := int(bk.first[n])
+	bk.flow = make([]int64, m)
+
+	bk.initInArcs(m)
+
+	for i, v := range data {
+		c := dimacsCapacity(v, precision)
+		bk.parent[i] = NOTHING
+		if c == 0 {
+			continue
+		}
+		if v > 0 {
+			bk.tr[i] = c
+			bk.tree[i] = BK_S
+		} else {
+			bk.tr[i] = -c
+			bk.tree[i] = BK_T
+		}
+		bk.parent[i] = BK_TERMINAL
+		bk.dist[i] = 1
+		bk.activate(int32(i))
+	}
+
+	return bk
+}
+
+// Index the offsets of the defs, or list the in arcs when the table would
+// be larger than the arcs or an offset is repeated in a def
+func (bk *boykovKolmogorov) initInArcs(m int) {
+
+	index := make(map[int]int)
+	for _, def := range bk.defs {
+		for _, off := range def {
+			if _, ok := index[off]; !ok {
+				index[off] = len(bk.offsets)
+				bk.offsets = append(bk.offsets, off)
+			}
+		}
+	}
+
+	implicit := len(bk.offsets) <= BK_MAX_OFFSETS && len(bk.defs)*len(bk.offsets) <= m
+	if implicit {
+		bk.position = make([][]int32, len(bk.defs))
+		for d, def := range bk.defs {
+			pos := make([]int32, len(bk.offsets))
+			for u := range pos {
+				pos[u] = NOTHING
+			}
+			for k, off := range def {
+				if u := index[off]; pos[u] == NOTHING {
+					pos[u] = int32(k)
+				} else {
+					implicit = false
+				}
+			}
+			bk.position[d] = pos
+		}
+	}
+
+	if implicit {
+		log.Debugf("Boykov-Kolmogorov in arcs from %v offsets", len(bk.offsets))
+		return
+	}
+
+	log.Debugf("Boykov-Kolmogorov in arcs listed, %v offsets", len(bk.offsets))
+
+	n := bk.numNodes
+	bk.offsets, bk.position = nil, nil
+	bk.inFirst = make([]int32, n+1)
+	bk.inArcs = make([]int32, m)
+	bk.inTails = make([]int32, m)
+
+	for i := 0; i < n; i++ {
+		if key := bk.keys[i]; key != MISSING {
+			for _, off := range bk.defs[key] {
+				bk.inFirst[i+off+1]++
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		bk.inFirst[i+1] += bk.inFirst[i]
+	}
+
+	fill := append([]int32(nil), bk.inFirst[:n]...)
+	for i := 0; i < n; i++ {
+		if key := bk.keys[i]; key != MISSING {
+			for k, off := range bk.defs[key] {
+				h := i + off
+				bk.inArcs[fill[h]] = bk.first[i] + int32(k)
+				bk.inTails[fill[h]] = int32(i)
+				fill[h]++
+			}
+		}
+	}
+}
+
+// Call visit for every arc of p with its other end q, out if it leaves p,
+// until visit returns false
+func (bk *boykovKolmogorov) neighbours(p int32, visit func(q, a int32, out bool) bool) {
+
+	if key := bk.keys[p]; key != MISSING {
+		for k, off := range bk.defs[key] {
+			if !visit(p+int32(off), bk.first[p]+int32(k), true) {
+				return
+			}
+		}
+	}
+
+	if bk.inFirst != nil {
+		for j := bk.inFirst[p]; j < bk.inFirst[p+1]; j++ {
+			if !visit(bk.inTails[j], bk.inArcs[j], false) {
+				return
+			}
+		}
+		return
+	}
+
+	for u, off := range bk.offsets {
+		q := int(p) - off
+		if q < 0 || q >= bk.numNodes {
+			continue
+		}
+		if key := bk.keys[q]; key != MISSING {
+			if k := bk.position[key][u]; k != NOTHING {
+				if !visit(int32(q), bk.first[q]+k, false) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// The residual capacity of arc a, along it or against it
+func (bk *boykovKolmogorov) residual(a int32, forward bool) int64 {
+	if forward {
+		return math.MaxInt64
+	}
+	return bk.flow[a]
+}
+
+func (bk *boykovKolmogorov) activate(p int32) {
+	if bk.isActive[p] {
+		return
+	}
+	bk.isActive[p] = true
+	bk.nextActive[p] = NOTHING
+	if bk.lastActive == NOTHING {
+		bk.firstActive = p
+	} else {
+		bk.nextActive[bk.lastActive] = p
+	}
+	bk.lastActive = p
+}
+
+// The next active node still in a tree
+func (bk *boykovKolmogorov) nextActiveNode() int32 {
+	for p := bk.firstActive; p != NOTHING; p = bk.firstActive {
+		bk.firstActive = bk.nextActive[p]
+		if bk.firstActive == NOTHING {
+			bk.lastActive = NOTHING
+		}
+		bk.isActive[p] = false
+		if bk.tree[p] != BK_FREE {
+			return p
+		}
+	}
+	return NOTHING
+}
+
+// Grow the trees from the active nodes, augmenting the paths found
+func (bk *boykovKolmogorov) solve() {
+
+	for p := bk.nextActiveNode(); p != NOTHING; p = bk.nextActiveNode() {
+
+		var from, to, arc int32 = NOTHING, NOTHING, NOTHING
+		var forward bool
+
+		bk.neighbours(p, func(q, a int32, out bool) bool {
+
+			// The residual capacity away from the root of p's tree
+			away := out
+			if bk.tree[p] == BK_T {
+				away = !out
+			}
+			if bk.residual(a, away) == 0 {
+				return true
+			}
+
+			switch bk.tree[q] {
+			case BK_FREE:
+				bk.tree[q] = bk.tree[p]
+				bk.parent[q] = p
+				bk.parentArc[q] = a
+				bk.parentOut[q] = !out
+				bk.stamp[q] = bk.stamp[p]
+				bk.dist[q] = bk.dist[p] + 1
+				bk.activate(q)
+			case bk.tree[p]:
+			default:
+				if bk.tree[p] == BK_S {
+					from, to, forward = p, q, out
+				} else {
+					from, to, forward = q, p, !out
+				}
+				arc = a
+				return false
+			}
+			return true
+		})
+
+		if arc == NOTHING {
+			continue
+		}
+
+		bk.clock++
+		bk.augment(from, to, arc, forward)
+		bk.adopt()
+
+		// p may have more paths
+		if bk.tree[p] != BK_FREE {
+			bk.activate(p)
+		}
+	}
+
+	log.Debugf(
+		"Boykov-Kolmogorov flow: %v, paths: %v, adopted: %v, freed: %v",
+		bk.total, bk.augments, bk.adopted, bk.freed,
+	)
+}
+
+// Push the bottleneck along the path source, from, to, sink, orphaning the
+// nodes whose arc to the parent is saturated
+func (bk *boykovKolmogorov) augment(from, to, arc int32, forward bool) {
+
+	delta := bk.residual(arc, forward)
+
+	u := from
+	for ; bk.parent[u] != BK_TERMINAL; u = bk.parent[u] {
+		if c := bk.residual(bk.parentArc[u], !bk.parentOut[u]); c < delta {
+			delta = c
+		}
+	}
+	if bk.tr[u] < delta {
+		delta = bk.tr[u]
+	}
+
+	u = to
+	for ; bk.parent[u] != BK_TERMINAL; u = bk.parent[u] {
+		if c := bk.residual(bk.parentArc[u], bk.parentOut[u]); c < delta {
+			delta = c
+		}
+	}
+	if -bk.tr[u] < delta {
+		delta = -bk.tr[u]
+	}
+
+	bk.push(arc, forward, delta)
+
+	for u = from; bk.parent[u] != BK_TERMINAL; {
+		p := bk.parent[u]
+		bk.push(bk.parentArc[u], !bk.parentOut[u], delta)
+		if bk.residual(bk.parentArc[u], !bk.parentOut[u]) == 0 {
+			bk.orphan(u)
+		}
+		u = p
+	}
+	if bk.tr[u] -= delta; bk.tr[u] == 0 {
+		bk.orphan(u)
+	}
+
+	for u = to; bk.parent[u] != BK_TERMINAL; {
+		p := bk.parent[u]
+		bk.push(bk.parentArc[u], bk.parentOut[u], delta)
+		if bk.residual(bk.parentArc[u], bk.parentOut[u]) == 0 {
+			bk.orphan(u)
+		}
+		u = p
+	}
+	if bk.tr[u] += delta; bk.tr[u] == 0 {
+		bk.orphan(u)
+	}
+
+	bk.total += delta
+	bk.augments++
+}
+
+// Move delta along arc a, or back against it
+func (bk *boykovKolmogorov) push(a int32, forward bool, delta int64) {
+	if forward {
+		bk.flow[a] += delta
+	} else {
+		bk.flow[a] -= delta
+	}
+}
+
+func (bk *boykovKolmogorov) orphan(u int32) {
+	bk.parent[u] = BK_ORPHAN
+	bk.orphans = append(bk.orphans, u)
+}
+
+// Find a new parent for every orphan, the nearest to the terminal of those
+// still rooted there, or free it along with the nodes hanging from it
+func (bk *boykovKolmogorov) adopt() {
+
+	for len(bk.orphans) > 0 {
+
+		p := bk.orphans[len(bk.orphans)-1]
+		bk.orphans = bk.orphans[:len(bk.orphans)-1]
+
+		t := bk.tree[p]
+		best, bestArc, bestOut := int32(NOTHING), int32(NOTHING), false
+		bestDist := int32(math.MaxInt32)
+
+		bk.neighbours(p, func(q, a int32, out bool) bool {
+			if bk.tree[q] != t || bk.residual(a, bk.towardP(t, out)) == 0 {
+				return true
+			}
+			if d := bk.rootDistance(q); d < bestDist {
+				best, bestArc, bestOut, bestDist = q, a, out, d
+			}
+			return true
+		})
+
+		if best != NOTHING {
+			bk.parent[p] = best
+			bk.parentArc[p] = bestArc
+			bk.parentOut[p] = bestOut
+			bk.stamp[p] = bk.clock
+			bk.dist[p] = bestDist + 1
+			bk.adopted++
+			continue
+		}
+
+		bk.neighbours(p, func(q, a int32, out bool) bool {
+			if bk.tree[q] != t {
+				return true
+			}
+			if bk.residual(a, bk.towardP(t, out)) > 0 {
+				bk.activate(q)
+			}
+			if bk.parent[q] == p {
+				bk.orphan(q)
+			}
+			return true
+		})
+
+		bk.tree[p] = BK_FREE
+		bk.parent[p] = NOTHING
+		bk.freed++
+	}
+}
+
+// Whether the residual arc of tree t from a neighbour to p runs along the
+// arc, out telling if the arc leaves p
+func (bk *boykovKolmogorov) towardP(t int8, out bool) bool {
+	if t == BK_S {
+		return !out
+	}
+	return out
+}
+
+// The distance of q to its terminal, or MaxInt32 if it hangs from an
+// orphan. The nodes on the way are stamped with their distance.
+func (bk *boykovKolmogorov) rootDistance(q int32) int32 {
+
+	var d int32
+	for j := q; ; j = bk.parent[j] {
+		if bk.stamp[j] == bk.clock {
+			d += bk.dist[j]
+			break
+		}
+		d++
+		if bk.parent[j] == BK_TERMINAL {
+			bk.stamp[j] = bk.clock
+			bk.dist[j] = 1
+			break
+		}
+		if bk.parent[j] == BK_ORPHAN || bk.parent[j] == NOTHING {
+			return math.MaxInt32
+		}
+	}
+
+	for j := q; bk.stamp[j] != bk.clock; j = bk.parent[j] {
+		bk.stamp[j] = bk.clock
+		bk.dist[j] = d
+		d--
+	}
+
+	return bk.dist[q]
+}
+
+// The blocks of the source tree, those the source reaches
+func (bk *boykovKolmogorov) sourceSet() []bool {
+	solution := make([]bool, bk.numNodes)
+	for i, t := range bk.tree {
+		solution[i] = t == BK_S
+	}
+	return solution
+}
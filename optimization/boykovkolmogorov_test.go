@@ -0,0 +1,60 @@
+package optimization
+
+import "testing"
+
+func boykovKolmogorovSolver(t *testing.T) closureSolver {
+	return func(data []float64, pre *Precedence, precision float64) ([]bool, int64) {
+		solver := &BoykovKolmogorov{Precision: precision}
+		pit, status := solver.computeSolution(nil, data, pre)
+		if status != 0 {
+			t.Fatalf("Boykov-Kolmogorov failed, status %v", status)
+		}
+		flow, _, _ := solver.maxFlow()
+		return pit, flow
+	}
+}
+
+func TestBoykovKolmogorov(t *testing.T) {
+	testClosureCases(t, boykovKolmogorovSolver(t))
+}
+
+func TestBoykovKolmogorovNewman1(t *testing.T) {
+	testNewman1(t, boykovKolmogorovSolver(t))
+}
+
+// A grid with the same offsets everywhere, whose in arcs are not listed,
+// against pseudoflow
+func TestBoykovKolmogorovGrid(t *testing.T) {
+
+	const nx, ny, nz = 7, 6, 4
+
+	data := make([]float64, nx*ny*nz)
+	preds := make([][]int, len(data))
+	for i := range data {
+		ix, iy, iz := i%nx, (i/nx)%ny, i/(nx*ny)
+		data[i] = float64((i*7919)%23 - 13)
+		if iz == nz-1 {
+			continue
+		}
+		for _, d := range [][2]int{{0, 0}, {-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			if x, y := ix+d[0], iy+d[1]; x >= 0 && x < nx && y >= 0 && y < ny {
+				preds[i] = append(preds[i], x+y*nx+(iz+1)*nx*ny)
+			}
+		}
+	}
+	pre := testPrecedence(len(data), preds)
+
+	if bk := newBoykovKolmogorov(data, pre, 1); bk.inFirst != nil {
+		t.Fatal("the in arcs of a uniform grid are listed")
+	}
+
+	want, wantFlow := pseudoflowSolver(t, false, false)(data, pre, 1)
+	value, _ := pitValue(data, want)
+
+	pit, flow := boykovKolmogorovSolver(t)(data, pre, 1)
+
+	checkPit(t, "grid", data, pre, 1, pit, flow, value)
+	if flow != wantFlow {
+		t.Errorf("max flow %v, pseudoflow %v", flow, wantFlow)
+	}
+}
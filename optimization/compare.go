@@ -0,0 +1,118 @@
+package optimization
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// Every problem solved by the engine is solved again by the engines of
+// compare, and their pits are compared with the engine's: the value, on the
+// values handed to the engines, and the blocks in one pit and not the
+// other. The engine's pit is the one kept. Optimal pits may differ by
+// blocks of no value, a difference in value is a bug.
+
+// The name of an engine type, for the logs
+func (param *ConfigParams) engineName(engineType int) string {
+
+	var name string
+
+	switch engineType {
+	case LERCHSGROSSMANN:
+		name = "Lerchs Grossmann"
+	case DIMACSPROGRAM:
+		name = "pseudoflow"
+		if len(param.DimacsPath) > 0 {
+			name = "dimacs program"
+		}
+	case PUSHRELABEL:
+		name = "push-relabel"
+	case BOYKOVKOLMOGOROV:
+		name = "Boykov-Kolmogorov"
+	default:
+		return fmt.Sprintf("engine %v", engineType)
+	}
+
+	return fmt.Sprintf("engine %v (%v)", engineType, name)
+}
+
+// Solve again with the engines of compare, logging how their pits differ
+// from pit, found by the engine in elapsed
+func (ctx *Parameters) compare(ch chan<- string, name string, data []float64, pre *Precedence, pit []bool, elapsed time.Duration, block func(i int) int) int {
+
+	value, count := pitValue(data, pit)
+	log.Infof("Comparing %v. %v: %v blocks, value %f, in %v", name, ctx.engineName(ctx.EngineType), count, value, elapsed)
+
+	for _, engineType := range ctx.Compare {
+
+		if engineType == ctx.EngineType {
+			continue
+		}
+
+		start := time.Now()
+		other, status := ctx.solveWith(ch, engineType, name, data, pre, block)
+		if status != 0 {
+			return status
+		}
+		took := time.Since(start)
+
+		otherValue, otherCount := pitValue(data, other)
+
+		var onlyPit, onlyOther []int
+		for i := range pit {
+			if pit[i] && !other[i] {
+				onlyPit = append(onlyPit, i)
+			} else if other[i] && !pit[i] {
+				onlyOther = append(onlyOther, i)
+			}
+		}
+
+		if len(onlyPit) == 0 && len(onlyOther) == 0 {
+			log.Infof("  %v agrees, in %v", ctx.engineName(engineType), took)
+			continue
+		}
+
+		log.Warnf(
+			"  %v differs, in %v: %v blocks, value %f, delta %f",
+			ctx.engineName(engineType), took, otherCount, otherValue, otherValue-value,
+		)
+		log.Warnf("    only in the pit of %v: %v", ctx.engineName(ctx.EngineType), ctx.blockList(onlyPit, block))
+		log.Warnf("    only in the pit of %v: %v", ctx.engineName(engineType), ctx.blockList(onlyOther, block))
+	}
+
+	return 0
+}
+
+// The value and block count of a pit
+func pitValue(data []float64, pit []bool) (float64, int) {
+	var value float64
+	var count int
+	for i, v := range pit {
+		if v {
+			value += data[i]
+			count++
+		}
+	}
+	return value, count
+}
+
+// The count of blocks and the first ones as ix,iy,iz
+func (ctx *Parameters) blockList(blocks []int, block func(i int) int) string {
+
+	if len(blocks) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, VERIFY_MAX_REPORT)
+	for _, i := range blocks {
+		if len(names) == VERIFY_MAX_REPORT {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, ctx.Input.Grid.gridName(block(i)))
+	}
+
+	return fmt.Sprintf("%v blocks, %v", len(blocks), strings.Join(names, " "))
+}
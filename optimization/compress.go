@@ -12,12 +12,91 @@ import (
 
 type (
 	Data struct {
+		Type    int `json:"type"`
 		Grid    `json:"grid"`
-		EbvCols int         `json:"ebv_column"`
+		EbvCols Column      `json:"ebv_column"`
 		Ebv     [][]float64 `json:"-"`
+
+		PrecFile string `json:"prec_file"`
+
+		// Density in t/m3, per block from a column or a constant
+		DensityCol Column  `json:"density_column"`
+		Density    float64 `json:"density"`
+		densities  []float64
+
+		// Geotechnical zone of every block
+		ZoneCol Column `json:"zone_column"`
+		zones   []int
+
+		// Grades and rock types, valued by the economics. The grades are
+		// by element then realization.
+		GradeCol  Column   `json:"grade_column"`
+		GradeCols []Column `json:"grade_columns"`
+		RockCol   Column   `json:"rock_column"`
+		grades    [][][]float64
+		rocks     []int
+
+		// Surfaces above which the blocks are air
+		Topography string `json:"topography"`
+		MinedOut   string `json:"mined_out"`
+		topoSurf   *Surface
+		minedSurf  *Surface
+		air        []bool
+
+		// Blocks forced in the pit, condensed problems only
+		forced []bool
 	}
 )
 
+const (
+	GSLIB   = 1
+	GZIP    = 2
+	MINELIB = 3
+)
+
+// Read the input file according to the input type. MineLib problems carry
+// their own precedence, so they are read into the whole Parameters.
+func (ctx *Parameters) initialize(infile string) error {
+	if ctx.Input.Type == MINELIB {
+		if len(ctx.Input.Topography) > 0 || len(ctx.Input.MinedOut) > 0 || ctx.Economics.enabled() || ctx.Economics.Mcaf.enabled() {
+			e := fmt.Errorf("ERROR: surfaces and economics need a block grid, not a MineLib input")
+			log.Error(e)
+			return e
+		}
+		return ctx.initializeFromMinelib(infile)
+	}
+	if e := ctx.Input.initialize(infile); e != nil {
+		return e
+	}
+	if e := ctx.Input.readSurfaces(); e != nil {
+		return e
+	}
+	if e := ctx.Input.initializeAir(); e != nil {
+		return e
+	}
+	if e := ctx.Economics.apply(&ctx.Input); e != nil {
+		return e
+	}
+	if len(ctx.Economics.ValueFile) > 0 {
+		return ctx.Economics.writeValues(&ctx.Input)
+	}
+	return nil
+}
+
+// Read a block model according to the input type
+func (block *Data) initialize(infile string) error {
+	switch block.Type {
+	case GSLIB:
+		return block.initializeFromGslib(infile)
+	case GZIP:
+		return block.initializeFromGzip(infile)
+	default:
+		e := fmt.Errorf("ERROR: invalid input type %v", block.Type)
+		log.Error(e)
+		return e
+	}
+}
+
 func (block *Data) initializeFromGzip(infile string) error {
 
 	f, e := os.Open(infile)
@@ -83,6 +162,23 @@ func (block *Data) initializeFromGzip(infile string) error {
 	return e
 }
 
+// The tonnage of block i
+func (block *Data) tonnage(i int) float64 {
+
+	if block.isAir(i) {
+		return 0
+	}
+
+	volume := block.Grid.SizX * block.Grid.SizY * block.Grid.SizZ
+
+	if block.densities != nil {
+		return block.densities[i] * volume
+	} else if block.Density > 0 {
+		return block.Density * volume
+	}
+	return volume
+}
+
 func compressEverything(mask []bool, data *Data, precedence *Precedence, condensedEBV *Data, condensedPre *Precedence) bool {
 
 	var count int
@@ -131,51 +227,48 @@ func compressData(mask []bool, data *Data, count int, condensedEBV *Data) bool {
 }
 
 func compressPrecedence(mask []bool, count int, pre *Precedence, condensedPre *Precedence) bool {
-	zeroesBefore := make([]int, len(pre.keys))
-	condensedPre.keys = make([]int, count)
 
-	var currentZeroes, currentKey int
-	j := count - 1
+	// The index of every kept block in the condensed data. Offsets may point
+	// either way (explicit precedence), so these are all needed up front.
+	newIndex := make([]int, len(pre.keys))
+	var j int
+	for i, v := range mask {
+		newIndex[i] = j
+		if v {
+			j++
+		}
+	}
+
+	condensedPre.keys = make([]int, count)
+	condensedPre.defs = nil
+	condensedPre.defIndex = nil
 
-	for i := len(pre.keys) - 1; i >= 0; i-- {
+	j = 0
+	for i, key := range pre.keys {
 
 		if !mask[i] {
-			currentZeroes++
-			zeroesBefore[i] = currentZeroes
-		} else if pre.keys[i] == -1 {
-			condensedPre.keys[j] = -1
-			j--
-			zeroesBefore[i] = currentZeroes
-		} else {
+			continue
+		}
+
+		condensedPre.keys[j] = MISSING
+
+		if key != MISSING {
 
 			thisNewDef := []int{}
 
-			for _, off := range pre.defs[pre.keys[i]] {
+			for _, off := range pre.defs[key] {
 				if mask[i+off] {
-					offZeroes := zeroesBefore[i+off]
-					thisNewDef = append(thisNewDef, off-currentZeroes+offZeroes)
+					thisNewDef = append(thisNewDef, newIndex[i+off]-j)
 				}
 			}
 
 			if len(thisNewDef) > 0 {
-
-				if len(condensedPre.defs) == 0 {
-					condensedPre.defs = append(condensedPre.defs, thisNewDef)
-				}
-
-				if !sliceEqual(condensedPre.defs[currentKey], thisNewDef) {
-					condensedPre.defs = append(condensedPre.defs, thisNewDef)
-					currentKey++
-				}
-				condensedPre.keys[j] = currentKey
-			} else {
-				condensedPre.keys[j] = -1
+				condensedPre.keys[j] = condensedPre.addToDefs(thisNewDef)
 			}
+		}
 
-			j--
-			zeroesBefore[i] = currentZeroes
-		} //else
-	} //i--
+		j++
+	}
 
 	return true
 }
@@ -244,6 +337,11 @@ func (grid *Grid) gridIndex2(ids []int) int {
 	return grid.gridIndex(ids[0], ids[1], ids[2])
 }
 
+// The Grid indexes of block k as "ix,iy,iz", for the logs
+func (grid *Grid) gridName(k int) string {
+	return fmt.Sprintf("%v,%v,%v", grid.gridIx(k), grid.gridIy(k), grid.gridIz(k))
+}
+
 /**
  * Params:
  *  k = The one dimensional Grid index.
@@ -0,0 +1,418 @@
+package optimization
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// Regions forced in or out of the pit. An excluded block is never mined, nor
+// is any block depending on it, the blocks below it in its cone. An
+// included block is mined along with the blocks it depends on, the engines
+// see it with a value larger than all the costs of the model together so
+// any pit is better with it. A region is the union of its block list, block
+// file, box and polygon.
+//
+// The lease is a polygon in plan, in the coordinates of the grid. The blocks
+// whose centroid is outside it are excluded, and the value lost is found by
+// solving again without the lease.
+
+type (
+	// ConstraintParams is loaded from json
+	ConstraintParams struct {
+		Include []Region     `json:"include"`
+		Exclude []Region     `json:"exclude"`
+		Lease   [][2]float64 `json:"lease"`
+	}
+
+	// Region is a set of blocks. The file holds "ix,iy,iz" lines, 0 indexed
+	// like the blocks, the box is ix, iy, iz from and to, inclusive. The
+	// polygon is x, y in plan, its blocks the ones whose centroid is inside,
+	// or outside if set, and, when max_z is above min_z, between those
+	// elevations.
+	Region struct {
+		Name    string       `json:"name"`
+		Blocks  [][3]int     `json:"blocks"`
+		File    string       `json:"file"`
+		Box     []int        `json:"box"`
+		Polygon [][2]float64 `json:"polygon"`
+		MinZ    float64      `json:"min_z"`
+		MaxZ    float64      `json:"max_z"`
+		Outside bool         `json:"outside"`
+	}
+)
+
+func (cp *ConstraintParams) enabled() bool {
+	return len(cp.Include) > 0 || len(cp.Exclude) > 0 || len(cp.Lease) > 0
+}
+
+// The blocks of the regions, nil if there are none
+func regionBlocks(pg *Grid, regions []Region) ([]bool, error) {
+
+	if len(regions) == 0 {
+		return nil, nil
+	}
+
+	in := make([]bool, pg.gridCount())
+
+	for k := range regions {
+		rg := &regions[k]
+		if len(rg.Name) == 0 {
+			rg.Name = fmt.Sprintf("region %v", k+1)
+		}
+		n, e := rg.mark(pg, in)
+		if e != nil {
+			return nil, fmt.Errorf("ERROR: %v: %v", rg.Name, e)
+		}
+		log.Infof("  %v: %v blocks", rg.Name, n)
+	}
+
+	return in, nil
+}
+
+// Mark the blocks of the region, return their count
+func (rg *Region) mark(pg *Grid, in []bool) (int, error) {
+
+	var count int
+	set := func(i int) {
+		if !in[i] {
+			in[i] = true
+			count++
+		}
+	}
+
+	for _, b := range rg.Blocks {
+		i, e := pg.checkedIndex(b[0], b[1], b[2])
+		if e != nil {
+			return 0, e
+		}
+		set(i)
+	}
+
+	if len(rg.File) > 0 {
+		blocks, e := readBlockList(rg.File, pg)
+		if e != nil {
+			return 0, fmt.Errorf("failed reading %v: %v", rg.File, e)
+		}
+		for _, i := range blocks {
+			set(i)
+		}
+	}
+
+	if len(rg.Box) > 0 {
+		if len(rg.Box) != 6 {
+			return 0, fmt.Errorf("box must be ix, iy, iz from and to. Supplied: %v", rg.Box)
+		}
+		lo, e := pg.checkedIndex(rg.Box[0], rg.Box[1], rg.Box[2])
+		if e != nil {
+			return 0, e
+		}
+		hi, e := pg.checkedIndex(rg.Box[3], rg.Box[4], rg.Box[5])
+		if e != nil {
+			return 0, e
+		}
+		for k, axis := range []string{"ix", "iy", "iz"} {
+			if rg.Box[k] > rg.Box[k+3] {
+				return 0, fmt.Errorf("box %v from %v is past to %v", axis, rg.Box[k], rg.Box[k+3])
+			}
+		}
+		for iz := pg.gridIz(lo); iz <= pg.gridIz(hi); iz++ {
+			for iy := pg.gridIy(lo); iy <= pg.gridIy(hi); iy++ {
+				for ix := pg.gridIx(lo); ix <= pg.gridIx(hi); ix++ {
+					set(pg.gridIndex(ix, iy, iz))
+				}
+			}
+		}
+	}
+
+	if len(rg.Polygon) > 0 {
+		if len(rg.Polygon) < 3 {
+			return 0, fmt.Errorf("polygon needs 3 vertices at least. Supplied: %v", len(rg.Polygon))
+		}
+		for iy := 0; iy < pg.NumY; iy++ {
+			for ix := 0; ix < pg.NumX; ix++ {
+				if insidePolygon(rg.Polygon, pg.MinX+float64(ix)*pg.SizX, pg.MinY+float64(iy)*pg.SizY) == rg.Outside {
+					continue
+				}
+				for iz := 0; iz < pg.NumZ; iz++ {
+					z := pg.benchElevation(iz)
+					if rg.MaxZ > rg.MinZ && (z < rg.MinZ || z > rg.MaxZ) {
+						continue
+					}
+					set(pg.gridIndex(ix, iy, iz))
+				}
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Is x, y inside the polygon, by the even-odd rule
+func insidePolygon(poly [][2]float64, x, y float64) bool {
+
+	inside := false
+
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		xi, yi := poly[i][0], poly[i][1]
+		xj, yj := poly[j][0], poly[j][1]
+		if (yi > y) != (yj > y) && x < xi+(y-yi)*(xj-xi)/(yj-yi) {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// Read the blocks of a csv file, one "ix,iy,iz" per line. A first line, past
+// the blank lines and comments, that is not numeric is taken as a header.
+func readBlockList(infile string, pg *Grid) ([]int, error) {
+
+	r, e := openInput(infile)
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+
+	var blocks []int
+
+	s := bufio.NewScanner(r)
+	line := 0
+	first := true
+
+	for s.Scan() {
+		line++
+
+		text := strings.TrimSpace(s.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		header := first
+		first = false
+
+		fields := strings.FieldsFunc(text, func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\t'
+		})
+		if len(fields) != 3 {
+			if header {
+				continue
+			}
+			return nil, fmt.Errorf("line %v: expected ix,iy,iz", line)
+		}
+
+		var ids [3]int
+		for k, f := range fields {
+			if ids[k], e = strconv.Atoi(f); e != nil {
+				break
+			}
+		}
+		if e != nil {
+			if header {
+				continue
+			}
+			return nil, fmt.Errorf("line %v: invalid index: %v", line, e)
+		}
+
+		i, e := pg.checkedIndex(ids[0], ids[1], ids[2])
+		if e != nil {
+			return nil, fmt.Errorf("line %v: block %v", line, e)
+		}
+		blocks = append(blocks, i)
+	}
+
+	return blocks, s.Err()
+}
+
+// The included and excluded blocks
+func (cp *ConstraintParams) regions(pg *Grid) (include, exclude []bool, e error) {
+
+	if len(cp.Include) > 0 {
+		log.Info("Included regions")
+		if include, e = regionBlocks(pg, cp.Include); e != nil {
+			return nil, nil, e
+		}
+	}
+
+	regions := cp.Exclude
+	if len(cp.Lease) > 0 {
+		regions = append(regions[:len(regions):len(regions)], Region{Name: "outside the lease", Polygon: cp.Lease, Outside: true})
+	}
+
+	if len(regions) > 0 {
+		log.Info("Excluded regions")
+		if exclude, e = regionBlocks(pg, regions); e != nil {
+			return nil, nil, e
+		}
+	}
+
+	return include, exclude, nil
+}
+
+// Add to blocked every block depending on one of them. Arcs may point
+// anywhere, the sweeps go on until nothing changes.
+func (prec *Precedence) dependents(blocked []bool) {
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(blocked) - 1; i >= 0; i-- {
+			if blocked[i] {
+				continue
+			}
+			if key := prec.keys[i]; key != MISSING {
+				for _, off := range prec.defs[key] {
+					if blocked[i+off] {
+						blocked[i] = true
+						changed = true
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// Apply the regions to the mask, before the precedence is generated, so
+// that the included blocks get their arcs
+func (ctx *Parameters) constrainMask(mask []bool) (include, exclude []bool, e error) {
+
+	if include, exclude, e = ctx.Constraints.regions(&ctx.Input.Grid); e != nil {
+		log.Error(e)
+		return nil, nil, e
+	}
+
+	for i := range mask {
+		if include != nil && include[i] {
+			mask[i] = true
+		} else if exclude != nil && exclude[i] {
+			mask[i] = false
+		}
+	}
+
+	return include, exclude, nil
+}
+
+// Take the excluded blocks and their dependents out of the mask, add the
+// included ones with what they depend on, checking they don't overlap
+func (ctx *Parameters) constrainPrecedence(mask, include, exclude []bool) error {
+
+	prec := &ctx.Precedence
+
+	if include != nil {
+		prec.closure(include)
+	}
+
+	var nIn, nOut int
+
+	if exclude != nil {
+		prec.dependents(exclude)
+		for i, v := range exclude {
+			if !v {
+				continue
+			}
+			if include != nil && include[i] {
+				pg := &ctx.Input.Grid
+				e := fmt.Errorf(
+					"ERROR: block %v,%v,%v is both included and excluded, with the blocks it depends on or below",
+					pg.gridIx(i), pg.gridIy(i), pg.gridIz(i),
+				)
+				log.Error(e)
+				return e
+			}
+			mask[i] = false
+			nOut++
+		}
+	}
+
+	for i, v := range include {
+		if v {
+			mask[i] = true
+			nIn++
+		}
+	}
+
+	log.Infof("Constrained blocks, included: %v, excluded: %v", nIn, nOut)
+
+	return nil
+}
+
+// The values with the forced blocks above the total cost, so that they are
+// in every optimal pit
+func forceValues(data []float64, forced func(i int) bool) []float64 {
+
+	var cost float64
+	for _, v := range data {
+		if v < 0 {
+			cost -= v
+		}
+	}
+	big := math.Max(1, 2*cost)
+
+	values := make([]float64, len(data))
+	for i, v := range data {
+		if forced(i) {
+			v = big
+		}
+		values[i] = v
+	}
+
+	return values
+}
+
+// Solve again without the lease, and log the value it costs
+func (ctx *Parameters) leaseLoss(ch chan<- string, selection [][]bool) error {
+
+	log.Info("Solving without the lease")
+	notifyStatus(ch, "Solving without the lease")
+
+	free := *ctx
+	free.Constraints.Lease = nil
+
+	// The precedence is generated again, unless it came with the input
+	if free.Method != EXPLICIT || len(free.File) > 0 {
+		free.keys, free.defs, free.defIndex = nil, nil, nil
+	}
+
+	pits, status := free.LG(ch)
+	if status != 0 {
+		e := fmt.Errorf("ERROR: failed solving without the lease")
+		log.Error(e)
+		return e
+	}
+
+	pg := &ctx.Input.Grid
+	outside := make([]bool, pg.gridCount())
+	lease := Region{Polygon: ctx.Constraints.Lease, Outside: true}
+	lease.mark(pg, outside)
+
+	log.Info("Value lost to the lease")
+	log.Info("  real          leased   unconstrained            lost  blocks outside")
+
+	for r := range selection {
+
+		var leased, unconstrained float64
+		var beyond int
+
+		for i, v := range selection[r] {
+			if v {
+				leased += ctx.Input.Ebv[r][i]
+			}
+		}
+		for i, v := range pits[r] {
+			if v {
+				unconstrained += ctx.Input.Ebv[r][i]
+				if outside[i] {
+					beyond++
+				}
+			}
+		}
+
+		log.Infof("  %4d %15.2f %15.2f %15.2f %15d", r, leased, unconstrained, unconstrained-leased, beyond)
+	}
+
+	return nil
+}
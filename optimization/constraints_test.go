@@ -0,0 +1,70 @@
+package optimization
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBlockList(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "whattle-test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	pg := &Grid{NumX: 4, NumY: 3, NumZ: 2}
+
+	cases := []struct {
+		name, text string
+		blocks     []int
+		fails      bool
+	}{
+		{"plain", "1,0,0\n2,1,1\n", []int{1, 18}, false},
+		{"header", "ix,iy,iz\n1,0,0\n", []int{1}, false},
+		{"comment then header", "# blocks\n\nix,iy,iz\n1,0,0\n3 2 1\n", []int{1, 23}, false},
+		{"header past the first line", "1,0,0\nix,iy,iz\n", nil, true},
+		{"outside the grid", "4,0,0\n", nil, true},
+	}
+
+	for k, c := range cases {
+		path := filepath.Join(dir, strings.Repeat("b", k+1)+".csv")
+		if e := ioutil.WriteFile(path, []byte(c.text), 0644); e != nil {
+			t.Fatal(e)
+		}
+		blocks, e := readBlockList(path, pg)
+		if (e != nil) != c.fails {
+			t.Errorf("%v: error %v", c.name, e)
+		} else if !c.fails && !reflect.DeepEqual(blocks, c.blocks) {
+			t.Errorf("%v: blocks %v, want %v", c.name, blocks, c.blocks)
+		}
+	}
+}
+
+func TestRegionBox(t *testing.T) {
+
+	pg := &Grid{NumX: 4, NumY: 3, NumZ: 2}
+
+	in, e := regionBlocks(pg, []Region{{Name: "pad", Box: []int{1, 0, 0, 2, 1, 1}}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var count int
+	for _, v := range in {
+		if v {
+			count++
+		}
+	}
+	if count != 8 {
+		t.Errorf("box of %v blocks, want 8", count)
+	}
+
+	_, e = regionBlocks(pg, []Region{{Name: "pad", Box: []int{2, 0, 0, 1, 1, 1}}})
+	if e == nil || !strings.Contains(e.Error(), "pad") {
+		t.Errorf("reversed box: error %v, want one naming the region", e)
+	}
+}
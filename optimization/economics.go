@@ -0,0 +1,377 @@
+package optimization
+
+import (
+	"fmt"
+	"math"
+
+	log "github.com/cihub/seelog"
+)
+
+type (
+	// EconomicParams is loaded from json. The value of a block comes from
+	// its grades, sent to the mill if it pays more than as waste:
+	//
+	//   waste = -tonnes * mining_cost
+	//   mill  = tonnes * (sum of grade * grade_factor * recovery * (price - selling_cost)
+	//           - mining_cost - processing_cost)
+	//
+	// grade_factor turns the grade into units of product per tonne, 0.01 for
+	// % and a price per tonne of metal for instance. The sum is over the
+	// elements, one per input grade column, or the single one given by the
+	// price, selling_cost, grade_factor and recovery. The rock types override
+	// the recovery, costs and density of their blocks, the mcaf scales the
+	// mining cost. Without prices the mcaf adjusts the input values.
+	EconomicParams struct {
+		Price          float64           `json:"price"`
+		SellingCost    float64           `json:"selling_cost"`
+		GradeFactor    float64           `json:"grade_factor"`
+		Recovery       float64           `json:"recovery"`
+		Elements       []Element         `json:"elements"`
+		MiningCost     float64           `json:"mining_cost"`
+		ProcessingCost float64           `json:"processing_cost"`
+		Rocks          []RockType        `json:"rocks"`
+		Mcaf           MiningCostFactors `json:"mcaf"`
+		ValueFile      string            `json:"value_file"`
+
+		elems   []Element
+		index   map[int]int
+		factors []float64
+	}
+
+	// Element is a product of the mill, its selling_cost covers the refining
+	// charges
+	Element struct {
+		Name        string  `json:"name"`
+		Unit        string  `json:"unit"`
+		Price       float64 `json:"price"`
+		SellingCost float64 `json:"selling_cost"`
+		GradeFactor float64 `json:"grade_factor"`
+		Recovery    float64 `json:"recovery"`
+	}
+
+	// RockType is the recovery and costs of a rock type, its density if
+	// there is no density column. The recoveries, by element, replace the
+	// recovery. The recovery and costs left out are the global ones.
+	RockType struct {
+		Rock           int       `json:"rock"`
+		Recovery       *float64  `json:"recovery"`
+		Recoveries     []float64 `json:"recoveries"`
+		MiningCost     *float64  `json:"mining_cost"`
+		ProcessingCost *float64  `json:"processing_cost"`
+		Density        float64   `json:"density"`
+	}
+)
+
+// Are the values computed
+func (ep *EconomicParams) enabled() bool {
+	return ep.Price > 0 || len(ep.Elements) > 0
+}
+
+func (ep *EconomicParams) check(block *Data) error {
+
+	if len(ep.Rocks) > 0 && block.rocks == nil {
+		return fmt.Errorf("ERROR: economics rocks need an input rock_column")
+	} else if ep.MiningCost < 0 || ep.ProcessingCost < 0 {
+		return fmt.Errorf("ERROR: economics costs must not be negative. Supplied: %v, %v", ep.MiningCost, ep.ProcessingCost)
+	} else if e := ep.checkElements(block); e != nil {
+		return e
+	}
+
+	ep.index = make(map[int]int)
+
+	for j, rt := range ep.Rocks {
+
+		if _, ok := ep.index[rt.Rock]; ok {
+			return fmt.Errorf("ERROR: duplicate rock %v", rt.Rock)
+		}
+		ep.index[rt.Rock] = j
+
+		recoveries := rt.Recoveries
+		if rt.Recovery != nil {
+			recoveries = append([]float64{*rt.Recovery}, recoveries...)
+		}
+		mining, processing := ep.costs(&rt)
+
+		if len(rt.Recoveries) > 0 && ep.enabled() && len(rt.Recoveries) != len(ep.elems) {
+			return fmt.Errorf("ERROR: rock %v needs %v recoveries, one per element. Supplied: %v", rt.Rock, len(ep.elems), len(rt.Recoveries))
+		} else if mining < 0 || processing < 0 {
+			return fmt.Errorf("ERROR: rock %v costs must not be negative. Supplied: %v, %v", rt.Rock, mining, processing)
+		} else if rt.Density < 0 {
+			return fmt.Errorf("ERROR: rock %v density must not be negative. Supplied: %v", rt.Rock, rt.Density)
+		}
+
+		for _, rec := range recoveries {
+			if rec < 0 || rec > 1 {
+				return fmt.Errorf("ERROR: rock %v recovery must be between 0 and 1. Supplied: %v", rt.Rock, rec)
+			}
+		}
+	}
+
+	if ep.Mcaf.enabled() {
+		if !ep.hasMiningCost() {
+			return fmt.Errorf("ERROR: mcaf needs a positive economics mining_cost, of all blocks or of a rock")
+		}
+		return ep.Mcaf.check(block)
+	}
+
+	return nil
+}
+
+// Is there a mining cost for the mcaf to scale
+func (ep *EconomicParams) hasMiningCost() bool {
+	if ep.MiningCost > 0 {
+		return true
+	}
+	for j := range ep.Rocks {
+		if mining, _ := ep.costs(&ep.Rocks[j]); mining > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The elements valued, one per grade column
+func (ep *EconomicParams) checkElements(block *Data) error {
+
+	if !ep.enabled() {
+		if block.grades != nil {
+			return fmt.Errorf("ERROR: an input grade_column needs the economics")
+		}
+		return nil
+	}
+
+	ep.elems = ep.Elements
+	if len(ep.elems) == 0 {
+		ep.elems = []Element{{
+			Name:        "grade",
+			Price:       ep.Price,
+			SellingCost: ep.SellingCost,
+			GradeFactor: ep.GradeFactor,
+			Recovery:    ep.Recovery,
+		}}
+	}
+
+	if block.grades == nil {
+		return fmt.Errorf("ERROR: economics need an input grade_column")
+	} else if len(block.grades) != len(ep.elems) {
+		return fmt.Errorf("ERROR: economics have %v elements but the input %v grade columns", len(ep.elems), len(block.grades))
+	}
+
+	for i := range ep.elems {
+		el := &ep.elems[i]
+		if len(el.Name) == 0 {
+			el.Name = fmt.Sprintf("element %v", i+1)
+		}
+		if el.GradeFactor == 0 {
+			el.GradeFactor = 1
+		}
+
+		if el.Price <= 0 {
+			return fmt.Errorf("ERROR: %v price must be positive. Supplied: %v", el.Name, el.Price)
+		} else if el.SellingCost < 0 || el.SellingCost >= el.Price {
+			return fmt.Errorf("ERROR: %v selling_cost must be between 0 and the price. Supplied: %v", el.Name, el.SellingCost)
+		} else if el.GradeFactor < 0 {
+			return fmt.Errorf("ERROR: %v grade_factor must be positive. Supplied: %v", el.Name, el.GradeFactor)
+		} else if el.Recovery < 0 || el.Recovery > 1 {
+			return fmt.Errorf("ERROR: %v recovery must be between 0 and 1. Supplied: %v", el.Name, el.Recovery)
+		}
+	}
+
+	return nil
+}
+
+// The rock type of block i, nil for the defaults
+func (ep *EconomicParams) rockType(block *Data, i int) *RockType {
+	if block.rocks != nil {
+		if j, ok := ep.index[block.rocks[i]]; ok {
+			return &ep.Rocks[j]
+		}
+	}
+	return nil
+}
+
+// The recovery of element e for the rock type, the element's if the rock
+// type has none
+func (ep *EconomicParams) recovery(rt *RockType, e int) float64 {
+	if rt == nil {
+		return ep.elems[e].Recovery
+	} else if len(rt.Recoveries) > 0 {
+		return rt.Recoveries[e]
+	} else if rt.Recovery != nil {
+		return *rt.Recovery
+	}
+	return ep.elems[e].Recovery
+}
+
+// The mining and processing costs of the rock type, the global ones for those
+// it has not
+func (ep *EconomicParams) costs(rt *RockType) (float64, float64) {
+	mining, processing := ep.MiningCost, ep.ProcessingCost
+	if rt != nil && rt.MiningCost != nil {
+		mining = *rt.MiningCost
+	}
+	if rt != nil && rt.ProcessingCost != nil {
+		processing = *rt.ProcessingCost
+	}
+	return mining, processing
+}
+
+// The value of block i in realization r, and whether it goes to the mill
+func (ep *EconomicParams) blockValue(block *Data, r, i int) (float64, bool) {
+
+	rt := ep.rockType(block, i)
+	mining, processing := ep.costs(rt)
+	mining *= ep.factor(i)
+
+	var revenue float64
+	for e, el := range ep.elems {
+		grade := math.Max(block.grades[e][r][i], 0)
+		revenue += grade * el.GradeFactor * ep.recovery(rt, e) * (el.Price - el.SellingCost)
+	}
+
+	tonnes := block.tonnage(i)
+	waste := -tonnes * mining
+	mill := tonnes * (revenue - mining - processing)
+
+	if mill > waste {
+		return mill, true
+	}
+	return waste, false
+}
+
+// Replace the grades by the values of the blocks, or adjust the values. The
+// air is left at 0.
+func (ep *EconomicParams) apply(block *Data) error {
+
+	if !ep.enabled() && !ep.Mcaf.enabled() {
+		if block.grades != nil {
+			e := fmt.Errorf("ERROR: an input grade_column needs the economics")
+			log.Error(e)
+			return e
+		}
+		return nil
+	}
+
+	if e := ep.check(block); e != nil {
+		log.Error(e)
+		return e
+	}
+
+	cnt := block.Grid.gridCount()
+
+	// Densities of the rock types
+	if block.densities == nil && block.rocks != nil {
+		for j := range ep.Rocks {
+			if ep.Rocks[j].Density > 0 {
+				// The others keep the constant density, or the volume
+				fallback := block.Density
+				if fallback <= 0 {
+					fallback = 1
+				}
+				block.densities = make([]float64, cnt)
+				for i := range block.densities {
+					block.densities[i] = fallback
+					if rt := ep.rockType(block, i); rt != nil && rt.Density > 0 {
+						block.densities[i] = rt.Density
+					}
+				}
+				break
+			}
+		}
+	}
+
+	if ep.Mcaf.enabled() {
+		ep.factors = ep.Mcaf.factors(block)
+	}
+
+	if !ep.enabled() {
+		ep.adjust(block)
+		return nil
+	}
+
+	log.Info("Valuing blocks")
+	for _, el := range ep.elems {
+		log.Infof("  %v price: %v, selling cost: %v, grade factor: %v", el.Name, el.Price, el.SellingCost, el.GradeFactor)
+	}
+
+	nReal := len(block.grades[0])
+	block.Ebv = make([][]float64, nReal)
+
+	for r := 0; r < nReal; r++ {
+
+		values := make([]float64, cnt)
+		var milled int
+		var millTonnes, total float64
+
+		for i := range values {
+			if block.isAir(i) {
+				continue
+			}
+			var mill bool
+			if values[i], mill = ep.blockValue(block, r, i); mill {
+				milled++
+				millTonnes += block.tonnage(i)
+			}
+			total += values[i]
+		}
+
+		block.Ebv[r] = values
+
+		log.Infof("  realization %3v. Milled blocks: %-6v, tonnes: %.0f, total value: %f", r, milled, millTonnes, total)
+	}
+
+	return nil
+}
+
+// Log the ore, waste and metal of the pits of every realization. Contained
+// is the metal of the whole pit, milled that of its ore and recovered what
+// the mill gets out of it.
+func (ep *EconomicParams) reportMetal(block *Data, pits [][]bool) {
+
+	log.Info("Metal in the pits")
+	log.Info("  real element              ore tonnes   waste tonnes        contained           milled        recovered")
+
+	for r, pit := range pits {
+
+		var ore, waste float64
+		contained := make([]float64, len(ep.elems))
+		milled := make([]float64, len(ep.elems))
+		recovered := make([]float64, len(ep.elems))
+
+		for i, v := range pit {
+			if !v {
+				continue
+			}
+
+			tonnes := block.tonnage(i)
+			_, mill := ep.blockValue(block, r, i)
+			rt := ep.rockType(block, i)
+
+			if mill {
+				ore += tonnes
+			} else {
+				waste += tonnes
+			}
+
+			for e, el := range ep.elems {
+				metal := tonnes * math.Max(block.grades[e][r][i], 0) * el.GradeFactor
+				contained[e] += metal
+				if mill {
+					milled[e] += metal
+					recovered[e] += metal * ep.recovery(rt, e)
+				}
+			}
+		}
+
+		for e, el := range ep.elems {
+			name := el.Name
+			if len(el.Unit) > 0 {
+				name = fmt.Sprintf("%v (%v)", el.Name, el.Unit)
+			}
+			log.Infof(
+				"  %4d %-18v %14.0f %14.0f %16.2f %16.2f %16.2f",
+				r, name, ore, waste, contained[e], milled[e], recovered[e],
+			)
+		}
+	}
+}
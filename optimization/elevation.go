@@ -0,0 +1,109 @@
+package optimization
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/cihub/seelog"
+)
+
+type (
+	// SlopeBand is the slope, or slope sectors, of the benches between two
+	// elevations, min_z included and max_z excluded
+	SlopeBand struct {
+		MinZ    float64      `json:"min_z"`
+		MaxZ    float64      `json:"max_z"`
+		Slope   float64      `json:"slope"`
+		Sectors SlopeSectors `json:"sectors"`
+	}
+)
+
+// The slope table of a band
+func (band *SlopeBand) table() SlopeSectors {
+	if len(band.Sectors) > 0 {
+		return band.Sectors
+	}
+	return constantSlope(band.Slope)
+}
+
+func (band *SlopeBand) String() string {
+	return fmt.Sprintf("%v to %v", band.MinZ, band.MaxZ)
+}
+
+// The elevation of the centroid of a bench, min_z being the lowest one
+func (grid *Grid) benchElevation(iz int) float64 {
+	return grid.MinZ + float64(iz)*grid.SizZ
+}
+
+func (prec *Precedence) checkElevation() error {
+
+	if prec.NumBenches < MIN_BENCHES || prec.NumBenches > MAX_BENCHES {
+		return fmt.Errorf(
+			"ERROR: benches must be between %v and %v. Supplied: %v",
+			MIN_BENCHES, MAX_BENCHES, prec.NumBenches,
+		)
+	} else if len(prec.Profile) == 0 {
+		return fmt.Errorf("ERROR: elevation precedence requires a profile")
+	}
+
+	sort.SliceStable(prec.Profile, func(i, j int) bool {
+		return prec.Profile[i].MinZ < prec.Profile[j].MinZ
+	})
+
+	for i := range prec.Profile {
+		band := &prec.Profile[i]
+		if band.MinZ >= band.MaxZ {
+			return fmt.Errorf("ERROR: profile min_z must be below max_z. Supplied: %v", band)
+		} else if i > 0 && band.MinZ < prec.Profile[i-1].MaxZ {
+			return fmt.Errorf("ERROR: profile bands %v and %v overlap", &prec.Profile[i-1], band)
+		}
+
+		if e := band.table().check(); e != nil {
+			return fmt.Errorf("%v (band %v)", e, band)
+		}
+	}
+
+	if e := prec.defaultTable().check(); e != nil {
+		return fmt.Errorf("%v (benches in no band)", e)
+	}
+
+	return nil
+}
+
+// A template for every band, plus the default one. As for the zones, the
+// bench above a block, where its wall starts, picks the template.
+func (prec *Precedence) genElevation(ctx *Parameters, mask []bool) {
+
+	pg := &ctx.Input.Grid
+
+	tmpls := make([]Template, len(prec.Profile)+1)
+
+	for i := range prec.Profile {
+		log.Infof("Band %v", &prec.Profile[i])
+		table := prec.Profile[i].table()
+		tmpls[i] = prec.benchTemplate(pg, table.flattest(), table.slopeAt)
+	}
+
+	log.Info("Benches in no band")
+	table := prec.defaultTable()
+	tmpls[len(prec.Profile)] = prec.benchTemplate(pg, table.flattest(), table.slopeAt)
+
+	// The template of every bench
+	benches := make([]int, pg.NumZ)
+	for z := range benches {
+		benches[z] = len(prec.Profile)
+		if z+1 < pg.NumZ {
+			elev := pg.benchElevation(z + 1)
+			for i := range prec.Profile {
+				if band := &prec.Profile[i]; band.MinZ <= elev && elev < band.MaxZ {
+					benches[z] = i
+					break
+				}
+			}
+		}
+	}
+
+	prec.applyTemplates(pg, mask, tmpls, func(loc int) int {
+		return benches[pg.gridIz(loc)]
+	})
+}
@@ -0,0 +1,213 @@
+package optimization
+
+import (
+	"math"
+	"testing"
+)
+
+// Closure problems small enough to be solved by hand, shared by the tests of
+// the max flow engines. preds are the predecessors of every block, pit the
+// optimal pit when it is the only one.
+var closureCases = []struct {
+	name   string
+	values []float64
+	preds  [][]int
+	value  float64
+	pit    []bool
+}{
+	{
+		name:   "single positive",
+		values: []float64{5},
+		value:  5,
+		pit:    []bool{true},
+	},
+	{
+		name:   "single negative",
+		values: []float64{-5},
+		pit:    []bool{false},
+	},
+	{
+		name:   "all positive",
+		values: []float64{3, 1, 4, 1, 5},
+		preds:  [][]int{nil, {0}, {1}, {1, 2}, {3}},
+		value:  14,
+		pit:    []bool{true, true, true, true, true},
+	},
+	{
+		name:   "all negative",
+		values: []float64{-1, -2, -3, -4},
+		preds:  [][]int{nil, {0}, {0, 1}, {2}},
+		pit:    []bool{false, false, false, false},
+	},
+	{
+		name:   "ore pays its waste",
+		values: []float64{-3, -4, 10},
+		preds:  [][]int{nil, nil, {0, 1}},
+		value:  3,
+		pit:    []bool{true, true, true},
+	},
+	{
+		name:   "ore does not pay its waste",
+		values: []float64{-6, -5, 10},
+		preds:  [][]int{nil, nil, {0, 1}},
+		pit:    []bool{false, false, false},
+	},
+	{
+		name:   "shared waste",
+		values: []float64{-10, 6, 6},
+		preds:  [][]int{nil, {0}, {0}},
+		value:  2,
+		pit:    []bool{true, true, true},
+	},
+	{
+		name:   "chain",
+		values: []float64{-1, -1, -1, 4, -2},
+		preds:  [][]int{nil, {0}, {1}, {2}, {3}},
+		value:  1,
+		pit:    []bool{true, true, true, true, false},
+	},
+	{
+		name:   "cone",
+		values: []float64{-1, -1, -1, -1, -1, -2, 4, -2, 12},
+		preds:  [][]int{nil, nil, nil, nil, nil, {0, 1, 2}, {1, 2, 3}, {2, 3, 4}, {5, 6, 7}},
+		value:  7,
+		pit:    []bool{true, true, true, true, true, true, true, true, true},
+	},
+	{
+		name:   "inner pit",
+		values: []float64{-1, -1, -1, -1, -1, -2, 4, -2, 5},
+		preds:  [][]int{nil, nil, nil, nil, nil, {0, 1, 2}, {1, 2, 3}, {2, 3, 4}, {5, 6, 7}},
+		value:  1,
+		pit:    []bool{false, true, true, true, false, false, true, false, false},
+	},
+	{
+		name:   "tie",
+		values: []float64{-5, 5},
+		preds:  [][]int{nil, {0}},
+	},
+	{
+		name:   "ties in a pit",
+		values: []float64{-2, -3, 5, 4, 0},
+		preds:  [][]int{nil, nil, {0, 1}, {0}, {3}},
+		value:  4,
+	},
+	{
+		name:   "zero values",
+		values: []float64{0, 0, 0},
+		preds:  [][]int{nil, {0}, {1}},
+	},
+	{
+		name:   "cycle",
+		values: []float64{4, -3, -2},
+		preds:  [][]int{{1}, {0}, {1}},
+		value:  1,
+		pit:    []bool{true, true, false},
+	},
+}
+
+// An engine solving data and pre, returning the pit and the max flow
+type closureSolver func(data []float64, pre *Precedence, precision float64) ([]bool, int64)
+
+// The precedence of the predecessors of every block
+func testPrecedence(n int, preds [][]int) *Precedence {
+	pre := &Precedence{keys: make([]int, n)}
+	for i := range pre.keys {
+		pre.keys[i] = MISSING
+		if i < len(preds) && len(preds[i]) > 0 {
+			def := make([]int, len(preds[i]))
+			for k, p := range preds[i] {
+				def[k] = p - i
+			}
+			pre.keys[i] = pre.addToDefs(def)
+		}
+	}
+	return pre
+}
+
+// Check a pit is closed, worth value and certified by flow, the capacity of
+// its cut
+func checkPit(t *testing.T, name string, data []float64, pre *Precedence, precision float64, pit []bool, flow int64, value float64) {
+
+	t.Helper()
+
+	if arcs := pre.violations(pit); len(arcs) > 0 {
+		t.Errorf("%v: the pit is not closed, block %v without %v", name, arcs[0][0], arcs[0][1])
+	}
+
+	got, _ := pitValue(data, pit)
+	if math.Abs(got-value) > 1e-6*math.Max(1, math.Abs(value)) {
+		t.Errorf("%v: pit value %v, want %v", name, got, value)
+	}
+
+	var cut int64
+	for i, v := range data {
+		if pit[i] == (v < 0) {
+			cut += dimacsCapacity(v, precision)
+		}
+	}
+	if cut != flow {
+		t.Errorf("%v: max flow %v, the cut of the pit is %v", name, flow, cut)
+	}
+}
+
+// Solve every closure case, checking the pit and flow against the optimum
+func testClosureCases(t *testing.T, solve closureSolver) {
+
+	for _, c := range closureCases {
+
+		pre := testPrecedence(len(c.values), c.preds)
+		pit, flow := solve(c.values, pre, 1)
+
+		checkPit(t, c.name, c.values, pre, 1, pit, flow, c.value)
+
+		var positive int64
+		for _, v := range c.values {
+			if v > 0 {
+				positive += int64(v)
+			}
+		}
+		if want := positive - int64(c.value); flow != want {
+			t.Errorf("%v: max flow %v, want %v", c.name, flow, want)
+		}
+
+		if c.pit != nil {
+			for i := range c.pit {
+				if pit[i] != c.pit[i] {
+					t.Errorf("%v: pit %v, want %v", c.name, pit, c.pit)
+					break
+				}
+			}
+		}
+	}
+}
+
+const (
+	NEWMAN1_UPIT      = "../test/minelib/newman1/newman1.upit"
+	NEWMAN1_PREC      = "../test/minelib/newman1/newman1.prec"
+	NEWMAN1_OBJECTIVE = 26086899.02597
+)
+
+// Solve the MineLib newman1 instance, whose optimum is published
+func testNewman1(t *testing.T, solve closureSolver) {
+
+	head, data, e := readMinelibUpit(NEWMAN1_UPIT)
+	if e != nil {
+		t.Skipf("newman1 not available: %v", e)
+	}
+
+	r, e := openInput(NEWMAN1_PREC)
+	if e != nil {
+		t.Skipf("newman1 not available: %v", e)
+	}
+	defer r.Close()
+
+	pre := new(Precedence)
+	if e = pre.readMinelibPrec(r, head.NBlocks); e != nil {
+		t.Fatal(e)
+	}
+
+	const precision = 1e5
+	pit, flow := solve(data, pre, precision)
+
+	checkPit(t, "newman1", data, pre, precision, pit, flow, NEWMAN1_OBJECTIVE)
+}
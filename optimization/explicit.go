@@ -0,0 +1,212 @@
+package optimization
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// Explicit precedence read from an arc file over the block grid, either in
+// the MineLib .prec layout (block, number of predecessors, predecessors,
+// blocks being grid indexes) or as csv lines "ix,iy,iz,pix,piy,piz" giving a
+// block and one of its predecessors, 0 indexed.
+
+const (
+	PREC_FORMAT = "prec"
+	CSV_FORMAT  = "csv"
+)
+
+// The format of the precedence file, from its extension unless given
+func (prec *Precedence) fileFormat() string {
+	if len(prec.Format) > 0 {
+		return strings.ToLower(prec.Format)
+	}
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(prec.File, ".gz")))
+	if ext == ".csv" {
+		return CSV_FORMAT
+	}
+	return PREC_FORMAT
+}
+
+func (prec *Precedence) readExplicit(pg *Grid) error {
+
+	format := prec.fileFormat()
+	if format != PREC_FORMAT && format != CSV_FORMAT {
+		return fmt.Errorf("ERROR: precedence format must be %q or %q. Supplied: %q", PREC_FORMAT, CSV_FORMAT, prec.Format)
+	}
+
+	r, e := openInput(prec.File)
+	if e != nil {
+		return fmt.Errorf("ERROR: failed opening precedence file %v: %v", prec.File, e)
+	}
+	defer r.Close()
+
+	if format == PREC_FORMAT {
+		e = prec.readMinelibPrec(r, pg.gridCount())
+	} else {
+		e = prec.readArcsCsv(r, pg)
+	}
+
+	if e != nil {
+		return fmt.Errorf("ERROR: failed reading precedence file %v: %v", prec.File, e)
+	}
+
+	if i := prec.cycleBlock(); i != NOTHING {
+		return fmt.Errorf("ERROR: precedence file %v has a cycle through block %v", prec.File, pg.gridName(i))
+	}
+
+	log.Infof("Read %v precedence from %v", format, prec.File)
+
+	return nil
+}
+
+// Read the arcs as csv, one per line. A first line, past the blank lines and
+// comments, that is not numeric is taken as a header.
+func (prec *Precedence) readArcsCsv(r io.Reader, pg *Grid) error {
+
+	count := pg.gridCount()
+	preds := make(map[int][]int)
+
+	s := bufio.NewScanner(r)
+	line := 0
+	first := true
+
+	for s.Scan() {
+		line++
+
+		text := strings.TrimSpace(s.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		header := first
+		first = false
+
+		fields := strings.Split(text, ",")
+		if len(fields) != 6 {
+			if header {
+				continue
+			}
+			return fmt.Errorf("line %v: expected ix,iy,iz,pix,piy,piz", line)
+		}
+
+		var ids [6]int
+		var e error
+		for i, f := range fields {
+			if ids[i], e = strconv.Atoi(strings.TrimSpace(f)); e != nil {
+				break
+			}
+		}
+		if e != nil {
+			if header {
+				continue
+			}
+			return fmt.Errorf("line %v: invalid index: %v", line, e)
+		}
+
+		id, e := pg.checkedIndex(ids[0], ids[1], ids[2])
+		if e != nil {
+			return fmt.Errorf("line %v: block %v", line, e)
+		}
+		p, e := pg.checkedIndex(ids[3], ids[4], ids[5])
+		if e != nil {
+			return fmt.Errorf("line %v: predecessor %v", line, e)
+		} else if p == id {
+			return fmt.Errorf("line %v: block %v,%v,%v precedes itself", line, ids[0], ids[1], ids[2])
+		}
+
+		preds[id] = append(preds[id], p-id)
+	}
+
+	if e := s.Err(); e != nil {
+		return e
+	}
+
+	prec.keys = make([]int, count)
+	for i := range prec.keys {
+		prec.keys[i] = MISSING
+	}
+	prec.defs = nil
+	prec.defIndex = nil
+
+	for id, offs := range preds {
+		sort.Ints(offs)
+		thisdef := offs[:0]
+		for i, off := range offs {
+			if i == 0 || off != offs[i-1] {
+				thisdef = append(thisdef, off)
+			}
+		}
+		prec.keys[id] = prec.addToDefs(thisdef)
+	}
+
+	return nil
+}
+
+// A block on a cycle of the precedence, NOTHING if there is none, by a
+// depth first search along the predecessors
+func (prec *Precedence) cycleBlock() int {
+
+	const (
+		unseen = iota
+		open
+		done
+	)
+
+	state := make([]int8, len(prec.keys))
+
+	type frame struct{ block, next int }
+	var stack []frame
+
+	for start := range prec.keys {
+
+		if state[start] != unseen {
+			continue
+		}
+		state[start] = open
+		stack = append(stack[:0], frame{start, 0})
+
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			i := top.block
+
+			var def []int
+			if key := prec.keys[i]; key != MISSING {
+				def = prec.defs[key]
+			}
+
+			if top.next == len(def) {
+				state[i] = done
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			p := i + def[top.next]
+			top.next++
+
+			switch state[p] {
+			case open:
+				return p
+			case unseen:
+				state[p] = open
+				stack = append(stack, frame{p, 0})
+			}
+		}
+	}
+
+	return NOTHING
+}
+
+// The grid index of a block, checking it is in the grid
+func (grid *Grid) checkedIndex(ix, iy, iz int) (int, error) {
+	if ix < 0 || ix >= grid.NumX || iy < 0 || iy >= grid.NumY || iz < 0 || iz >= grid.NumZ {
+		return 0, fmt.Errorf("%v,%v,%v is outside the grid", ix, iy, iz)
+	}
+	return grid.gridIndex(ix, iy, iz), nil
+}
@@ -0,0 +1,400 @@
+package optimization
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// The closure graph is written in DIMACS max flow format. Node 1 is the
+// source, nodes 2..n+1 are the blocks and n+2 is the sink. Positive blocks
+// hang off the source, negative blocks drain into the sink and every
+// precedence is an arc of "infinite" capacity.
+
+const (
+	DIMACS_SOURCE = 1
+	// Placeholders in dimacs_args replaced by the graph and solution files
+	DIMACS_INPUT  = "{input}"
+	DIMACS_OUTPUT = "{output}"
+	// How long the output of a killed program is waited for
+	DIMACS_WAIT_DELAY = 2 * time.Second
+)
+
+// The capacity of a block's source or sink arc
+func dimacsCapacity(v, precision float64) int64 {
+	return int64(math.Abs(v) * precision)
+}
+
+// A capacity larger than any cut, used for the precedence arcs
+func dimacsInfinity(data []float64, precision float64) int64 {
+	inf := int64(1)
+	for _, v := range data {
+		if v >= 0 {
+			inf += dimacsCapacity(v, precision)
+		}
+	}
+	return inf
+}
+
+// Write the closure graph of data and pre in DIMACS max flow format. If
+// blocks is given, the block index of every node is written as comments.
+func writeDimacs(w io.Writer, data []float64, pre *Precedence, precision float64, blocks []int) error {
+
+	count := len(data)
+	numNodes := count + 2
+	numArcs := count
+
+	for i := 0; i < count; i++ {
+		if ind := pre.keys[i]; ind != MISSING {
+			numArcs += len(pre.defs[ind])
+		}
+	}
+
+	sink := numNodes
+	inf := dimacsInfinity(data, precision)
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "c closure graph of %v blocks, precision %v\n", count, precision)
+	fmt.Fprintf(bw, "p max %d %d\n", numNodes, numArcs)
+	fmt.Fprintf(bw, "n %d s\n", DIMACS_SOURCE)
+	fmt.Fprintf(bw, "n %d t\n", sink)
+
+	if blocks != nil {
+		fmt.Fprintln(bw, "c node to block index:")
+		for i, b := range blocks {
+			fmt.Fprintf(bw, "c m %d %d\n", i+2, b)
+		}
+	}
+
+	for i, v := range data {
+		if v < 0 {
+			fmt.Fprintf(bw, "a %d %d %d\n", i+2, sink, dimacsCapacity(v, precision))
+		} else {
+			fmt.Fprintf(bw, "a %d %d %d\n", DIMACS_SOURCE, i+2, dimacsCapacity(v, precision))
+		}
+	}
+
+	// Now the infinite ones
+	for i := 0; i < count; i++ {
+		if ind := pre.keys[i]; ind != MISSING {
+			for _, off := range pre.defs[ind] {
+				fmt.Fprintf(bw, "a %d %d %d\n", i+2, i+off+2, inf)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Solve using the external program at DimacsPath. The graph is handed over
+// as a file and the program is expected to print the nodes of the source
+// set ("n NODE"), or the flow on the arcs ("f FROM TO FLOW") from which the
+// source set is recovered.
+func (solver *DimacsSolver) runExternal(ch chan<- string, data []float64, pre *Precedence) ([]bool, int) {
+
+	notifyStatus(ch, "Writing dimacs graph")
+
+	graph, e := ioutil.TempFile("", "whattle-*.max")
+	if e != nil {
+		log.Errorf("Error: failed creating dimacs graph file: %v", e)
+		return nil, 1
+	}
+	defer os.Remove(graph.Name())
+
+	e = writeDimacs(graph, data, pre, solver.Precision, nil)
+	if ce := graph.Close(); e == nil {
+		e = ce
+	}
+	if e != nil {
+		log.Errorf("Error: failed writing dimacs graph %v: %v", graph.Name(), e)
+		return nil, 1
+	}
+
+	outfile := graph.Name() + ".out"
+	defer os.Remove(outfile)
+
+	//-------------------------------
+
+	ctx := context.Background()
+	if solver.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(solver.Timeout*float64(time.Second)))
+		defer cancel()
+	}
+
+	args, useStdin, useStdout := solver.externalArgs(graph.Name(), outfile)
+	cmd := exec.CommandContext(ctx, solver.DimacsPath, args...)
+	killGroupOnCancel(cmd)
+	// Past the kill, stop waiting for output held open by stray processes
+	cmd.WaitDelay = DIMACS_WAIT_DELAY
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if useStdin {
+		in, e := os.Open(graph.Name())
+		if e != nil {
+			log.Errorf("Error: failed opening dimacs graph %v: %v", graph.Name(), e)
+			return nil, 1
+		}
+		defer in.Close()
+		cmd.Stdin = in
+	}
+
+	notifyStatus(ch, "Running dimacs program")
+	log.Infof("Running %v %v", solver.DimacsPath, strings.Join(args, " "))
+
+	start := time.Now()
+	e = cmd.Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Errorf("Error: dimacs program %v timed out after %v seconds", solver.DimacsPath, solver.Timeout)
+		return nil, 1
+	} else if e != nil {
+		log.Errorf("Error: dimacs program %v failed: %v: %v", solver.DimacsPath, e, lastLines(stderr.String(), 5))
+		return nil, 1
+	}
+
+	log.Infof("Dimacs program finished in %v", time.Since(start))
+
+	//-------------------------------
+
+	var out io.Reader = &stdout
+	if !useStdout {
+		f, e := os.Open(outfile)
+		if e != nil {
+			log.Errorf("Error: dimacs program %v did not write %v: %v", solver.DimacsPath, outfile, e)
+			return nil, 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	notifyStatus(ch, "Reading dimacs solution")
+
+	sol, e := readDimacsSolution(out, len(data)+2)
+	if e != nil {
+		log.Errorf("Error: failed reading output of dimacs program %v: %v", solver.DimacsPath, e)
+		return nil, 1
+	}
+
+	solution, e := sol.sourceSet(data, pre, solver.Precision)
+	if e != nil {
+		log.Errorf("Error: invalid output of dimacs program %v: %v", solver.DimacsPath, e)
+		return nil, 1
+	}
+
+	if sol.hasValue {
+		log.Infof("Dimacs program flow value: %v", sol.value)
+		solver.flow, solver.hasFlow = int64(math.Round(sol.value)), true
+	}
+
+	return solution, 0
+}
+
+// The arguments of the external program, replacing the placeholders. Without
+// them the graph goes to stdin and the solution is read from stdout.
+func (solver *DimacsSolver) externalArgs(infile, outfile string) (args []string, useStdin, useStdout bool) {
+
+	useStdin, useStdout = true, true
+
+	for _, arg := range solver.DimacsArgs {
+		if strings.Contains(arg, DIMACS_INPUT) {
+			arg = strings.Replace(arg, DIMACS_INPUT, infile, -1)
+			useStdin = false
+		}
+		if strings.Contains(arg, DIMACS_OUTPUT) {
+			arg = strings.Replace(arg, DIMACS_OUTPUT, outfile, -1)
+			useStdout = false
+		}
+		args = append(args, arg)
+	}
+
+	return
+}
+
+//---------------------------------------------------------------------------
+
+type (
+	// DimacsSolution is the output of an external max flow program
+	DimacsSolution struct {
+		numNodes int
+		hasValue bool
+		value    float64
+		cut      []bool
+		hasCut   bool
+		flows    map[[2]int]int64
+	}
+)
+
+func readDimacsSolution(r io.Reader, numNodes int) (*DimacsSolution, error) {
+
+	sol := &DimacsSolution{
+		numNodes: numNodes,
+		cut:      make([]bool, numNodes+1),
+		flows:    make(map[[2]int]int64),
+	}
+
+	node := func(s string) (int, error) {
+		n, e := strconv.Atoi(s)
+		if e != nil || n < 1 || n > numNodes {
+			return 0, fmt.Errorf("invalid node %q", s)
+		}
+		return n, nil
+	}
+
+	s := bufio.NewScanner(r)
+	line := 0
+
+	for s.Scan() {
+		line++
+
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var e error
+
+		switch fields[0] {
+		case "s":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %v: missing flow value", line)
+			}
+			if sol.value, e = strconv.ParseFloat(fields[1], 64); e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			}
+			sol.hasValue = true
+
+		case "n":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %v: missing node", line)
+			}
+			var n int
+			if n, e = node(fields[1]); e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			}
+			sol.cut[n] = true
+			sol.hasCut = true
+
+		case "f", "a":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %v: expected FROM TO FLOW", line)
+			}
+			var from, to int
+			var flow int64
+			if from, e = node(fields[1]); e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			} else if to, e = node(fields[2]); e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			} else if flow, e = strconv.ParseInt(fields[3], 10, 64); e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			}
+			if flow != 0 {
+				sol.flows[[2]int{from, to}] += flow
+			}
+		}
+	}
+
+	if e := s.Err(); e != nil {
+		return nil, e
+	}
+
+	if !sol.hasCut && len(sol.flows) == 0 && !sol.hasValue {
+		return nil, fmt.Errorf("no cut or flow found")
+	}
+
+	return sol, nil
+}
+
+// The blocks in the source set of the min cut, as printed by the program or
+// as reached from the source in the residual graph of the flow.
+func (sol *DimacsSolution) sourceSet(data []float64, pre *Precedence, precision float64) ([]bool, error) {
+
+	count := len(data)
+	solution := make([]bool, count)
+
+	if sol.hasCut {
+		if !sol.cut[DIMACS_SOURCE] {
+			return nil, fmt.Errorf("source is not in the source set")
+		} else if sol.cut[count+2] {
+			return nil, fmt.Errorf("sink is in the source set")
+		}
+		for i := range solution {
+			solution[i] = sol.cut[i+2]
+		}
+		return solution, nil
+	}
+
+	if len(sol.flows) == 0 && sol.value != 0 {
+		return nil, fmt.Errorf("only the flow value found, need the cut or the flows")
+	}
+
+	// Reverse residual arcs, only where there is flow
+	into := make(map[int][]int)
+	for arc, flow := range sol.flows {
+		if flow > 0 && arc[0] != DIMACS_SOURCE {
+			into[arc[1]] = append(into[arc[1]], arc[0])
+		}
+	}
+
+	var stack IntStack
+
+	for i, v := range data {
+		if v >= 0 && sol.flows[[2]int{DIMACS_SOURCE, i + 2}] < dimacsCapacity(v, precision) {
+			solution[i] = true
+			stack.push(i)
+		}
+	}
+
+	for stack.notEmpty() {
+		i := stack.pop()
+
+		if data[i] < 0 && sol.flows[[2]int{i + 2, count + 2}] < dimacsCapacity(data[i], precision) {
+			return nil, fmt.Errorf("flow is not maximum, sink reached from block %v", i)
+		}
+
+		visit := func(j int) {
+			if !solution[j] {
+				solution[j] = true
+				stack.push(j)
+			}
+		}
+
+		if key := pre.keys[i]; key != MISSING {
+			for _, off := range pre.defs[key] {
+				visit(i + off)
+			}
+		}
+
+		for _, n := range into[i+2] {
+			if n != count+2 {
+				visit(n - 2)
+			}
+		}
+	}
+
+	return solution, nil
+}
+
+// The last n lines of s, for error messages
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "; ")
+}
@@ -0,0 +1,190 @@
+package optimization
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExternalArgs(t *testing.T) {
+
+	cases := []struct {
+		args                []string
+		want                []string
+		useStdin, useStdout bool
+	}{
+		{nil, nil, true, true},
+		{[]string{"-v"}, []string{"-v"}, true, true},
+		{[]string{"{input}", "{output}"}, []string{"g.max", "g.out"}, false, false},
+		{[]string{"--in={input}", "-q"}, []string{"--in=g.max", "-q"}, false, true},
+		{[]string{"-o", "{output}"}, []string{"-o", "g.out"}, true, false},
+		{[]string{"{input}:{output}"}, []string{"g.max:g.out"}, false, false},
+	}
+
+	for _, c := range cases {
+		solver := &DimacsSolver{DimacsArgs: c.args}
+		args, useStdin, useStdout := solver.externalArgs("g.max", "g.out")
+		if !reflect.DeepEqual(args, c.want) || useStdin != c.useStdin || useStdout != c.useStdout {
+			t.Errorf("%q: %q, stdin %v, stdout %v, want %q, %v, %v", c.args, args, useStdin, useStdout, c.want, c.useStdin, c.useStdout)
+		}
+	}
+}
+
+// Run stub programs in place of a max flow program, on the graph of three
+// blocks, an ore under two wastes, checking the pit and flow read back
+func TestRunExternal(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub programs are shell scripts")
+	}
+
+	dir, e := ioutil.TempDir("", "whattle-test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	pre := testPrecedence(3, [][]int{nil, nil, {0, 1}})
+
+	cases := []struct {
+		name    string
+		values  []float64
+		args    []string
+		script  string
+		timeout float64
+		status  int
+		pit     []bool
+		flow    int64
+		hasFlow bool
+	}{
+		{
+			name:   "files",
+			values: []float64{-3, -4, 10},
+			args:   []string{"{input}", "{output}"},
+			script: `grep -q "^p max 5 5$" "$1" || exit 2
+printf "c cut\ns 7\nn 1\nn 2\nn 3\nn 4\n" > "$2"`,
+			pit:     []bool{true, true, true},
+			flow:    7,
+			hasFlow: true,
+		},
+		{
+			name:   "stdin and stdout",
+			values: []float64{-3, -4, 10},
+			args:   []string{"-q"},
+			script: `[ "$1" = "-q" ] || exit 2
+grep -q "^a 4 2 " || exit 2
+printf "s 7\nn 1\nn 2\nn 3\nn 4\n"`,
+			pit:     []bool{true, true, true},
+			flow:    7,
+			hasFlow: true,
+		},
+		{
+			name:   "no flow value",
+			values: []float64{-3, -4, 10},
+			args:   []string{"{input}", "{output}"},
+			script: `printf "n 1\nn 2\nn 3\nn 4\n" > "$2"`,
+			pit:    []bool{true, true, true},
+		},
+		{
+			name:    "flows of a pit",
+			values:  []float64{-3, -4, 10},
+			script:  `printf "s 7\nf 1 4 7\nf 4 2 3\nf 4 3 4\nf 2 5 3\nf 3 5 4\n"`,
+			pit:     []bool{true, true, true},
+			flow:    7,
+			hasFlow: true,
+		},
+		{
+			name:   "flows of no pit",
+			values: []float64{-6, -5, 10},
+			script: `printf "f 1 4 10\nf 4 2 6\nf 4 3 4\nf 2 5 6\nf 3 5 4\n"`,
+			pit:    []bool{false, false, false},
+		},
+		{
+			name:   "flow not maximum",
+			values: []float64{-3, -4, 10},
+			script: `printf "f 1 4 3\nf 4 2 3\nf 2 5 3\n"`,
+			status: 1,
+		},
+		{
+			name:   "only the flow value",
+			values: []float64{-3, -4, 10},
+			script: `printf "s 7\n"`,
+			status: 1,
+		},
+		{
+			name:   "no solution",
+			values: []float64{-3, -4, 10},
+			script: `echo "c nothing to say"`,
+			status: 1,
+		},
+		{
+			name:   "no output file",
+			values: []float64{-3, -4, 10},
+			args:   []string{"{input}", "{output}"},
+			script: `true`,
+			status: 1,
+		},
+		{
+			name:   "failure",
+			values: []float64{-3, -4, 10},
+			script: `echo "out of memory" >&2; exit 3`,
+			status: 1,
+		},
+		{
+			name:    "timeout",
+			values:  []float64{-3, -4, 10},
+			script:  `exec sleep 10`,
+			timeout: 0.2,
+			status:  1,
+		},
+		{
+			name:    "timeout of a wrapper",
+			values:  []float64{-3, -4, 10},
+			script:  `sleep 10; echo done`,
+			timeout: 0.2,
+			status:  1,
+		},
+		{
+			name:   "timeout of a background child",
+			values: []float64{-3, -4, 10},
+			script: `sleep 10 &
+wait`,
+			timeout: 0.2,
+			status:  1,
+		},
+	}
+
+	for k, c := range cases {
+
+		path := filepath.Join(dir, "stub"+string(rune('a'+k)))
+		if e := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+c.script+"\n"), 0755); e != nil {
+			t.Fatal(e)
+		}
+
+		solver := &DimacsSolver{Precision: 1, DimacsPath: path, DimacsArgs: c.args, Timeout: c.timeout}
+
+		start := time.Now()
+		pit, status := solver.computeSolution(nil, c.values, pre)
+
+		if status != c.status {
+			t.Errorf("%v: status %v, want %v", c.name, status, c.status)
+			continue
+		} else if c.timeout > 0 && time.Since(start) > 5*time.Second {
+			t.Errorf("%v: took %v", c.name, time.Since(start))
+		}
+		if status != 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(pit, c.pit) {
+			t.Errorf("%v: pit %v, want %v", c.name, pit, c.pit)
+		}
+		if flow, _, ok := solver.maxFlow(); ok != c.hasFlow || flow != c.flow {
+			t.Errorf("%v: max flow %v %v, want %v %v", c.name, flow, ok, c.flow, c.hasFlow)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package optimization
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Start the program in its own process group and kill the whole group when
+// the timeout fires, so that the children of a wrapper script go too
+func killGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package optimization
+
+import (
+	"os/exec"
+)
+
+// Only the program itself is killed when the timeout fires
+func killGroupOnCancel(cmd *exec.Cmd) {
+}
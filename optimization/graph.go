@@ -0,0 +1,90 @@
+package optimization
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// Write the closure graph of one realization in DIMACS max flow format, built
+// exactly as it is handed to the engines.
+func ExportGraph(opt RunCtx) error {
+
+	log.Infof("Begin parsing parameters from %v", opt.ParamFile)
+	notifyStatus(opt.Notify, "Parsing parameters file")
+
+	var params Parameters
+
+	if e := readJSONFile(opt.ParamFile, &params); e != nil {
+		return e
+	}
+
+	log.Infof("Begin reading input from %v", opt.InputFile)
+	notifyStatus(opt.Notify, "Reading input data")
+
+	if e := params.initialize(opt.InputFile); e != nil {
+		return e
+	}
+
+	if nReal := len(params.Input.Ebv); opt.Realization < 0 || opt.Realization >= nReal {
+		e := fmt.Errorf("ERROR: realization must be between 0 and %v. Supplied: %v", nReal-1, opt.Realization)
+		log.Error(e)
+		return e
+	}
+
+	mask, condensedEBV, condensedPre, status := params.condense(opt.Notify)
+	if status != 0 {
+		e := fmt.Errorf("Failed building the graph")
+		log.Error(e)
+		return e
+	}
+
+	// The block of every node
+	blocks := make([]int, 0, len(condensedEBV.Ebv[0]))
+	for i, v := range mask {
+		if v {
+			blocks = append(blocks, i)
+		}
+	}
+
+	log.Infof("Writing graph of realization %v to %v", opt.Realization, opt.OutputFile)
+	notifyStatus(opt.Notify, "Writing graph")
+
+	file, e := os.Create(opt.OutputFile)
+	if e != nil {
+		e = fmt.Errorf("Failed to create output file %v: %v", opt.OutputFile, e)
+		log.Error(e)
+		return e
+	}
+
+	var writer io.Writer = file
+	var zipwriter *gzip.Writer
+
+	if strings.HasSuffix(opt.OutputFile, ".gz") {
+		zipwriter = gzip.NewWriter(file)
+		writer = zipwriter
+	}
+
+	data := condensedEBV.engineValues(condensedEBV.Ebv[opt.Realization], nil)
+
+	e = writeDimacs(writer, data, condensedPre, params.ConfigParams.precision(), blocks)
+	if zipwriter != nil {
+		if ce := zipwriter.Close(); e == nil {
+			e = ce
+		}
+	}
+	if ce := file.Close(); e == nil {
+		e = ce
+	}
+
+	if e != nil {
+		e = fmt.Errorf("Failed to write output file %v: %v", opt.OutputFile, e)
+		log.Error(e)
+	}
+
+	return e
+}
@@ -0,0 +1,359 @@
+package optimization
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+type (
+	// Column selects a variable of a GEOEAS file, either by its 1 indexed
+	// position or by its name. In json it is given as a number or a string.
+	Column struct {
+		Index int
+		Name  string
+	}
+
+	// GslibHeader is the header of a GEOEAS (GSLIB) file
+	GslibHeader struct {
+		Title string
+		Names []string
+	}
+)
+
+func (col *Column) UnmarshalJSON(b []byte) error {
+
+	var name string
+	if e := json.Unmarshal(b, &name); e == nil {
+		col.Index = 0
+		col.Name = strings.TrimSpace(name)
+		return nil
+	}
+
+	var index int
+	if e := json.Unmarshal(b, &index); e != nil {
+		return fmt.Errorf("column must be a 1 indexed number or a name: %s", string(b))
+	}
+
+	col.Index = index
+	col.Name = ""
+	return nil
+}
+
+func (col Column) MarshalJSON() ([]byte, error) {
+	if len(col.Name) > 0 {
+		return json.Marshal(col.Name)
+	}
+	return json.Marshal(col.Index)
+}
+
+// Is the column specified at all
+func (col Column) isSet() bool {
+	return col.Index != 0 || len(col.Name) > 0
+}
+
+func (col Column) String() string {
+	if len(col.Name) > 0 {
+		return fmt.Sprintf("%q", col.Name)
+	}
+	return strconv.Itoa(col.Index)
+}
+
+// Return the 0 indexed position of the column in the header
+func (col Column) resolve(head *GslibHeader) (int, error) {
+
+	if len(col.Name) > 0 {
+		for i, name := range head.Names {
+			if strings.EqualFold(name, col.Name) {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("column %v not found in %v", col, head.Names)
+	}
+
+	if col.Index < 1 || col.Index > len(head.Names) {
+		return -1, fmt.Errorf(
+			"column must be between 1 and %v. Supplied: %v",
+			len(head.Names), col.Index,
+		)
+	}
+
+	return col.Index - 1, nil
+}
+
+// Open a file for reading, transparently decompressing gzip files
+func openInput(infile string) (io.ReadCloser, error) {
+
+	f, e := os.Open(infile)
+	if e != nil {
+		return nil, e
+	}
+
+	b := bufio.NewReader(f)
+
+	magic, _ := b.Peek(2)
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return readCloser{b, f.Close}, nil
+	}
+
+	r, e := gzip.NewReader(b)
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+
+	return readCloser{r, func() error {
+		r.Close()
+		return f.Close()
+	}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
+
+// Read a GEOEAS file calling fn with the values of the requested columns for
+// every row. The slice given to fn is reused between rows.
+func readGslib(infile string, cols []Column, fn func(row []float64) error) (*GslibHeader, error) {
+
+	r, e := openInput(infile)
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	next := func() (string, error) {
+		if !s.Scan() {
+			if e := s.Err(); e != nil {
+				return "", e
+			}
+			return "", io.ErrUnexpectedEOF
+		}
+		line++
+		return strings.TrimSpace(s.Text()), nil
+	}
+
+	//-------------------------------
+	// Header
+
+	head := &GslibHeader{}
+
+	if head.Title, e = next(); e != nil {
+		return nil, fmt.Errorf("missing title: %v", e)
+	}
+
+	text, e := next()
+	if e != nil {
+		return nil, fmt.Errorf("missing number of variables: %v", e)
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("line %v: missing number of variables", line)
+	}
+
+	nvar, e := strconv.Atoi(fields[0])
+	if e != nil || nvar < 1 {
+		return nil, fmt.Errorf("line %v: invalid number of variables %q", line, fields[0])
+	}
+
+	for i := 0; i < nvar; i++ {
+		if text, e = next(); e != nil {
+			return nil, fmt.Errorf("missing name of variable %v: %v", i+1, e)
+		}
+		head.Names = append(head.Names, text)
+	}
+
+	idx := make([]int, len(cols))
+	for i, col := range cols {
+		if idx[i], e = col.resolve(head); e != nil {
+			return nil, e
+		}
+	}
+
+	//-------------------------------
+	// Values
+
+	row := make([]float64, len(cols))
+
+	for s.Scan() {
+		line++
+
+		fields = strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) < nvar {
+			return nil, fmt.Errorf("line %v: expected %v values, found %v", line, nvar, len(fields))
+		}
+
+		for i, j := range idx {
+			if row[i], e = strconv.ParseFloat(fields[j], 64); e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			}
+		}
+
+		if e = fn(row); e != nil {
+			return nil, e
+		}
+	}
+
+	if e = s.Err(); e != nil {
+		return nil, e
+	}
+
+	return head, nil
+}
+
+func (block *Data) initializeFromGslib(infile string) error {
+
+	if block.GradeCol.isSet() && len(block.GradeCols) > 0 {
+		e := fmt.Errorf("ERROR: grade_column and grade_columns can't both be given")
+		log.Error(e)
+		return e
+	}
+
+	ebvCol := block.EbvCols
+	if !ebvCol.isSet() {
+		ebvCol = Column{Index: 1}
+	}
+
+	cnt := block.Grid.gridCount()
+	block.Ebv = [][]float64{}
+	idx := 0
+	realisation := make([]float64, cnt)
+
+	// Grades to value, or the value itself
+	valueCol := ebvCol
+	gradeCols := block.gradeColumns()
+	if len(gradeCols) > 0 {
+		valueCol = gradeCols[0]
+	}
+
+	cols := []Column{valueCol}
+
+	// The other grades, a layer per realization like the first
+	var more []int
+	var moreValues [][]float64
+	var moreLayers [][][]float64
+	for i := 1; i < len(gradeCols); i++ {
+		more = append(more, len(cols))
+		cols = append(cols, gradeCols[i])
+		moreValues = append(moreValues, make([]float64, cnt))
+		moreLayers = append(moreLayers, nil)
+	}
+
+	// Extra columns, taken from the first realization
+	density := -1
+	if block.DensityCol.isSet() {
+		density = len(cols)
+		cols = append(cols, block.DensityCol)
+		block.densities = make([]float64, cnt)
+	}
+
+	zone := -1
+	if block.ZoneCol.isSet() {
+		zone = len(cols)
+		cols = append(cols, block.ZoneCol)
+		block.zones = make([]int, cnt)
+	}
+
+	rock := -1
+	if block.RockCol.isSet() {
+		rock = len(cols)
+		cols = append(cols, block.RockCol)
+		block.rocks = make([]int, cnt)
+	}
+
+	head, e := readGslib(infile, cols, func(row []float64) error {
+		if len(block.Ebv) == 0 {
+			if density >= 0 {
+				block.densities[idx] = row[density]
+			}
+			if zone >= 0 {
+				block.zones[idx] = int(math.Round(row[zone]))
+			}
+			if rock >= 0 {
+				block.rocks[idx] = int(math.Round(row[rock]))
+			}
+		}
+
+		realisation[idx] = row[0]
+		for k, j := range more {
+			moreValues[k][idx] = row[j]
+		}
+		// one layer has been read,begin next layer
+		if idx++; idx >= cnt {
+			layer := make([]float64, cnt)
+			copy(layer, realisation)
+			block.Ebv = append(block.Ebv, layer)
+			for k := range more {
+				layer = make([]float64, cnt)
+				copy(layer, moreValues[k])
+				moreLayers[k] = append(moreLayers[k], layer)
+			}
+			idx = 0
+		}
+		return nil
+	})
+
+	if e != nil {
+		e = fmt.Errorf("Error: failed initializing data from input file %v: %v", infile, e)
+	} else if idx != 0 {
+		e = fmt.Errorf("Error: failed initializing data from input file %v: %v rows is not a multiple of the grid size %v", infile, len(block.Ebv)*cnt+idx, cnt)
+	} else if len(block.Ebv) == 0 {
+		e = fmt.Errorf("ERROR: no data")
+	}
+
+	if e != nil {
+		log.Error(e)
+		return e
+	}
+
+	log.Infof("Read %v: %v", infile, head.Title)
+	if len(gradeCols) > 0 {
+		log.Infof("  variables: %v, grade columns: %v", len(head.Names), gradeCols)
+		block.grades = append([][][]float64{block.Ebv}, moreLayers...)
+		block.Ebv = nil
+	} else {
+		log.Infof("  variables: %v, ebv column: %v", len(head.Names), valueCol)
+	}
+	if density >= 0 {
+		log.Infof("  density column: %v", block.DensityCol)
+	}
+	if zone >= 0 {
+		log.Infof("  zone column: %v", block.ZoneCol)
+	}
+	if rock >= 0 {
+		log.Infof("  rock column: %v", block.RockCol)
+	}
+
+	return nil
+}
+
+// The grade columns, either the list or the single one
+func (block *Data) gradeColumns() []Column {
+	if len(block.GradeCols) > 0 {
+		return block.GradeCols
+	} else if block.GradeCol.isSet() {
+		return []Column{block.GradeCol}
+	}
+	return nil
+}
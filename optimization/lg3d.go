@@ -5,14 +5,16 @@ import (
 )
 
 const (
-	LERCHSGROSSMANN = 1 //required downstream
-	DIMACSPROGRAM   = 2 //required downstream
-	PLUS            = true
-	MINUS           = false
-	STRONG          = true
-	WEAK            = false
-	ROOT            = -1
-	NOTHING         = -1
+	LERCHSGROSSMANN  = 1 //required downstream
+	DIMACSPROGRAM    = 2 //required downstream
+	PUSHRELABEL      = 3
+	BOYKOVKOLMOGOROV = 4
+	PLUS             = true
+	MINUS            = false
+	STRONG           = true
+	WEAK             = false
+	ROOT             = -1
+	NOTHING          = -1
 )
 
 type (
@@ -22,6 +24,14 @@ type (
 		Precision   float64 `json:"precision"`
 		LowestLabel bool    `json:"lowest_label"`
 		FifoBuckets bool    `json:"fifo_buckets"`
+		// External max flow program, with its arguments and timeout in seconds
+		DimacsPath string   `json:"dimacs_path"`
+		DimacsArgs []string `json:"dimacs_args"`
+		Timeout    float64  `json:"timeout"`
+		// Check every pit, and the cut against the flow
+		Verify bool `json:"verify"`
+		// Engines solving every problem again, to compare their pits
+		Compare []int `json:"compare"`
 	}
 	// UEngine was UltpitEngine
 	UEngine interface {
@@ -66,6 +76,10 @@ func getEngine(param *ConfigParams) (UEngine, error) {
 		return new(LG3D), nil
 	case DIMACSPROGRAM:
 		return newDimacsEngine(param), nil
+	case PUSHRELABEL:
+		return newPushRelabelEngine(param), nil
+	case BOYKOVKOLMOGOROV:
+		return newBoykovKolmogorovEngine(param), nil
 	default:
 		return nil, fmt.Errorf("Invalid engine type")
 	}
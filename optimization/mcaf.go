@@ -0,0 +1,207 @@
+package optimization
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// Hauling gets dearer with depth. The mining cost adjustment factors (MCAF)
+// multiply the mining cost of a block by a factor of its level, the bench
+// elevation or the depth of the centroid below the topography, interpolated
+// between the points of the table and constant past its ends.
+
+const (
+	MCAF_ELEVATION = "elevation"
+	MCAF_DEPTH     = "depth"
+)
+
+type (
+	// MiningCostFactors is the MCAF table, by elevation unless the
+	// reference is depth
+	MiningCostFactors struct {
+		Reference string      `json:"reference"`
+		Table     []McafPoint `json:"table"`
+	}
+
+	// McafPoint is the factor at a level, elevation or depth
+	McafPoint struct {
+		Level  float64 `json:"level"`
+		Factor float64 `json:"factor"`
+	}
+)
+
+func (mc *MiningCostFactors) enabled() bool {
+	return len(mc.Table) > 0
+}
+
+func (mc *MiningCostFactors) check(block *Data) error {
+
+	ref := strings.ToLower(mc.Reference)
+	if ref != "" && ref != MCAF_ELEVATION && ref != MCAF_DEPTH {
+		return fmt.Errorf("ERROR: mcaf reference must be %q or %q. Supplied: %q", MCAF_ELEVATION, MCAF_DEPTH, mc.Reference)
+	} else if ref == MCAF_DEPTH && block.topoSurf == nil {
+		return fmt.Errorf("ERROR: mcaf by depth needs the input topography")
+	}
+
+	sort.Slice(mc.Table, func(i, j int) bool {
+		return mc.Table[i].Level < mc.Table[j].Level
+	})
+
+	for i, pt := range mc.Table {
+		if pt.Factor <= 0 {
+			return fmt.Errorf("ERROR: mcaf factors must be positive. Supplied: %v at %v", pt.Factor, pt.Level)
+		} else if i > 0 && pt.Level == mc.Table[i-1].Level {
+			return fmt.Errorf("ERROR: mcaf level %v is given twice", pt.Level)
+		}
+	}
+
+	return nil
+}
+
+// The factor at a level
+func (mc *MiningCostFactors) factor(level float64) float64 {
+
+	tbl := mc.Table
+	j := sort.Search(len(tbl), func(j int) bool {
+		return tbl[j].Level >= level
+	})
+
+	if j == 0 {
+		return tbl[0].Factor
+	} else if j == len(tbl) {
+		return tbl[len(tbl)-1].Factor
+	}
+
+	lo, hi := tbl[j-1], tbl[j]
+	return lo.Factor + (hi.Factor-lo.Factor)*(level-lo.Level)/(hi.Level-lo.Level)
+}
+
+// The factors of every block
+func (mc *MiningCostFactors) factors(block *Data) []float64 {
+
+	pg := &block.Grid
+	columns := pg.NumX * pg.NumY
+	byDepth := strings.ToLower(mc.Reference) == MCAF_DEPTH
+
+	factors := make([]float64, pg.gridCount())
+	var missing int
+
+	for k := 0; k < columns; k++ {
+
+		// Depth from the top of the grid where the topography is unknown
+		top := pg.benchElevation(pg.NumZ-1) + pg.SizZ/2.0
+		if byDepth {
+			if z := block.topographyAt(k); !math.IsNaN(z) {
+				top = z
+			} else {
+				missing++
+			}
+		}
+
+		for iz := 0; iz < pg.NumZ; iz++ {
+			level := pg.benchElevation(iz)
+			if byDepth {
+				level = top - level
+			}
+			factors[k+iz*columns] = mc.factor(level)
+		}
+	}
+
+	ref := MCAF_ELEVATION
+	if byDepth {
+		ref = MCAF_DEPTH
+	}
+	log.Infof("Mining cost factors by %v, from %v to %v", ref, mc.Table[0].Factor, mc.Table[len(mc.Table)-1].Factor)
+	if missing > 0 {
+		log.Warnf("  columns with no topography, depth from the top of the grid: %v", missing)
+	}
+
+	return factors
+}
+
+// The mining cost factor of block i, 1 without a table
+func (ep *EconomicParams) factor(i int) float64 {
+	if ep.factors == nil {
+		return 1
+	}
+	return ep.factors[i]
+}
+
+// Take the extra mining cost off values read from the input
+func (ep *EconomicParams) adjust(block *Data) {
+
+	log.Info("Adjusting block values")
+
+	for r, values := range block.Ebv {
+		var total float64
+		for i := range values {
+			if block.isAir(i) {
+				continue
+			}
+			mining, _ := ep.costs(ep.rockType(block, i))
+			values[i] -= block.tonnage(i) * mining * (ep.factor(i) - 1)
+			total += values[i]
+		}
+		log.Infof("  realization %3v. Total value: %f", r, total)
+	}
+}
+
+// Write the values of the blocks as GSLIB, the realizations one after the
+// other, with their mining cost factor
+func (ep *EconomicParams) writeValues(block *Data) error {
+
+	file, e := os.Create(ep.ValueFile)
+	if e != nil {
+		e = fmt.Errorf("Failed to create value file %v: %v", ep.ValueFile, e)
+		log.Error(e)
+		return e
+	}
+
+	var writer io.Writer = file
+	var zipwriter *gzip.Writer
+	if strings.HasSuffix(ep.ValueFile, ".gz") {
+		zipwriter = gzip.NewWriter(file)
+		writer = zipwriter
+	}
+
+	w := bufio.NewWriter(writer)
+
+	fmt.Fprintln(w, "ultpit values")
+	fmt.Fprintln(w, "2")
+	fmt.Fprintln(w, "value")
+	fmt.Fprintln(w, "mcaf")
+
+	for _, values := range block.Ebv {
+		for i, v := range values {
+			fmt.Fprintf(w, "%g %g\n", v, ep.factor(i))
+		}
+	}
+
+	e = w.Flush()
+	if zipwriter != nil {
+		if ce := zipwriter.Close(); e == nil {
+			e = ce
+		}
+	}
+	if ce := file.Close(); e == nil {
+		e = ce
+	}
+
+	if e != nil {
+		e = fmt.Errorf("Failed to write value file %v: %v", ep.ValueFile, e)
+		log.Error(e)
+		return e
+	}
+
+	log.Infof("Wrote block values to %v", ep.ValueFile)
+
+	return nil
+}
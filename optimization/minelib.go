@@ -0,0 +1,284 @@
+package optimization
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// MineLib instances (http://mansci-web.uai.cl/minelib) describe the problem
+// explicitly: the .upit file holds the objective function and the .prec file
+// the predecessors of every block. Blocks are numbered from 0 and are laid
+// out along x in a 1 x n grid so the rest of the pipeline can use them as is.
+
+type (
+	// MinelibHeader is the header of a MineLib problem file
+	MinelibHeader struct {
+		Name    string
+		Type    string
+		NBlocks int
+	}
+)
+
+// The precedence file of a MineLib problem, unless given in the params
+func (block *Data) minelibPrecFile(infile string) string {
+	if len(block.PrecFile) > 0 {
+		return block.PrecFile
+	}
+	return strings.TrimSuffix(infile, filepath.Ext(infile)) + ".prec"
+}
+
+// Read a MineLib UPIT problem, the objective function in infile and the
+// precedence from the matching .prec file.
+func (ctx *Parameters) initializeFromMinelib(infile string) error {
+
+	head, ebv, e := readMinelibUpit(infile)
+	if e != nil {
+		e = fmt.Errorf("Error: failed initializing data from input file %v: %v", infile, e)
+		log.Error(e)
+		return e
+	}
+
+	log.Infof("Read %v: %v (%v), blocks: %v", infile, head.Name, head.Type, head.NBlocks)
+
+	ctx.Input.Grid = Grid{
+		NumX: head.NBlocks, NumY: 1, NumZ: 1,
+		SizX: 1.0, SizY: 1.0, SizZ: 1.0,
+	}
+	ctx.Input.Ebv = [][]float64{ebv}
+
+	precfile := ctx.Input.minelibPrecFile(infile)
+
+	r, e := openInput(precfile)
+	if e != nil {
+		log.Errorf("Error: failed initializing precedence from %v: %v", precfile, e)
+		return e
+	}
+	defer r.Close()
+
+	ctx.Precedence.Method = EXPLICIT
+	if e = ctx.Precedence.readMinelibPrec(r, head.NBlocks); e != nil {
+		e = fmt.Errorf("Error: failed initializing precedence from %v: %v", precfile, e)
+		log.Error(e)
+		return e
+	}
+
+	if i := ctx.Precedence.cycleBlock(); i != NOTHING {
+		e = fmt.Errorf("Error: precedence %v has a cycle through block %v", precfile, i)
+		log.Error(e)
+		return e
+	}
+
+	log.Infof("Read %v", precfile)
+
+	return nil
+}
+
+// Read the header and the objective function of a .upit file
+func readMinelibUpit(infile string) (*MinelibHeader, []float64, error) {
+
+	r, e := openInput(infile)
+	if e != nil {
+		return nil, nil, e
+	}
+	defer r.Close()
+
+	s := bufio.NewScanner(r)
+	line := 0
+	head := &MinelibHeader{}
+
+	// Header, up to the objective function
+	for done := false; !done; {
+		if !s.Scan() {
+			return nil, nil, fmt.Errorf("missing OBJECTIVE_FUNCTION")
+		}
+		line++
+
+		text := strings.TrimSpace(s.Text())
+		if len(text) == 0 || text[0] == '%' {
+			continue
+		}
+
+		key, value := text, ""
+		if i := strings.Index(text, ":"); i >= 0 {
+			key, value = strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+		}
+
+		switch strings.ToUpper(key) {
+		case "NAME":
+			head.Name = value
+		case "TYPE":
+			head.Type = value
+		case "NBLOCKS":
+			if head.NBlocks, e = strconv.Atoi(value); e != nil || head.NBlocks < 1 {
+				return nil, nil, fmt.Errorf("line %v: invalid NBLOCKS %q", line, value)
+			}
+		case "OBJECTIVE_FUNCTION":
+			done = true
+		default:
+			return nil, nil, fmt.Errorf("line %v: unknown keyword %q", line, key)
+		}
+	}
+
+	if head.NBlocks == 0 {
+		return nil, nil, fmt.Errorf("missing NBLOCKS")
+	} else if len(head.Type) > 0 && !strings.EqualFold(head.Type, "UPIT") {
+		return nil, nil, fmt.Errorf("unsupported problem type %q", head.Type)
+	}
+
+	ebv := make([]float64, head.NBlocks)
+	seen := make([]bool, head.NBlocks)
+
+	for s.Scan() {
+		line++
+
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0][0] == '%' {
+			continue
+		} else if strings.EqualFold(fields[0], "EOF") {
+			break
+		} else if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("line %v: expected block and value", line)
+		}
+
+		id, e := strconv.Atoi(fields[0])
+		if e != nil || id < 0 || id >= head.NBlocks {
+			return nil, nil, fmt.Errorf("line %v: invalid block %q", line, fields[0])
+		}
+
+		if ebv[id], e = strconv.ParseFloat(fields[1], 64); e != nil {
+			return nil, nil, fmt.Errorf("line %v: %v", line, e)
+		}
+		seen[id] = true
+	}
+
+	if e = s.Err(); e != nil {
+		return nil, nil, e
+	}
+
+	for id, ok := range seen {
+		if !ok {
+			return nil, nil, fmt.Errorf("missing value for block %v", id)
+		}
+	}
+
+	return head, ebv, nil
+}
+
+// Read a .prec file, each line is a block, its number of predecessors and
+// the predecessors.
+func (prec *Precedence) readMinelibPrec(r io.Reader, count int) error {
+
+	prec.keys = make([]int, count)
+	for i := range prec.keys {
+		prec.keys[i] = MISSING
+	}
+	prec.defs = nil
+	prec.defIndex = nil
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+
+	for s.Scan() {
+		line++
+
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0][0] == '%' {
+			continue
+		} else if strings.EqualFold(fields[0], "EOF") {
+			break
+		} else if len(fields) < 2 {
+			return fmt.Errorf("line %v: expected block and number of predecessors", line)
+		}
+
+		values := make([]int, len(fields))
+		for i, f := range fields {
+			v, e := strconv.Atoi(f)
+			if e != nil || v < 0 {
+				return fmt.Errorf("line %v: invalid value %q", line, f)
+			}
+			values[i] = v
+		}
+
+		id, n := values[0], values[1]
+		if id >= count {
+			return fmt.Errorf("line %v: block %v out of range", line, id)
+		} else if len(values) != n+2 {
+			return fmt.Errorf("line %v: expected %v predecessors, found %v", line, n, len(values)-2)
+		} else if prec.keys[id] != MISSING {
+			return fmt.Errorf("line %v: duplicate block %v", line, id)
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		thisdef := make([]int, 0, n)
+		for _, p := range values[2:] {
+			if p >= count || p == id {
+				return fmt.Errorf("line %v: invalid predecessor %v of block %v", line, p, id)
+			}
+			thisdef = append(thisdef, p-id)
+		}
+
+		prec.keys[id] = prec.addToDefs(thisdef)
+	}
+
+	return s.Err()
+}
+
+// Write the selected blocks of a single realization as a MineLib solution,
+// one block per line.
+func writeMinelibSol(w io.Writer, selection []bool) error {
+
+	bw := bufio.NewWriter(w)
+	for i, v := range selection {
+		if v {
+			if _, e := fmt.Fprintln(bw, i); e != nil {
+				return e
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Read a MineLib solution. Only the first value of every line, the block,
+// is used.
+func readMinelibSol(infile string, count int) ([]bool, error) {
+
+	r, e := openInput(infile)
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+
+	selection := make([]bool, count)
+
+	s := bufio.NewScanner(r)
+	line := 0
+
+	for s.Scan() {
+		line++
+
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0][0] == '%' {
+			continue
+		} else if strings.EqualFold(fields[0], "EOF") {
+			break
+		}
+
+		id, e := strconv.Atoi(fields[0])
+		if e != nil || id < 0 || id >= count {
+			return nil, fmt.Errorf("line %v: invalid block %q", line, fields[0])
+		}
+		selection[id] = true
+	}
+
+	return selection, s.Err()
+}
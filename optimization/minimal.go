@@ -0,0 +1,108 @@
+package optimization
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// The minimal arc set drops every template offset that can be reached by a
+// chain of two or more other offsets. The chain has to stay inside the box
+// spanned by the block and the predecessor, which is inside the grid
+// whenever both ends are, so the closure is the same at the grid edges.
+// Every step goes up a bench at least, so the chains are short.
+//
+// A chain starts with an offset of the block's own template, the blocks
+// along it may use another template, so the following steps are taken from
+// the offsets common to all templates.
+
+// Reduce the templates to their minimal arc sets
+func minimalTemplates(tmpls []Template) []Template {
+
+	common := make(map[[3]int]bool)
+	for _, off := range tmpls[0] {
+		common[off] = true
+	}
+	for _, tmpl := range tmpls[1:] {
+		in := make(map[[3]int]bool, len(tmpl))
+		for _, off := range tmpl {
+			in[off] = true
+		}
+		for off := range common {
+			if !in[off] {
+				delete(common, off)
+			}
+		}
+	}
+
+	var steps Template
+	for _, off := range tmpls[0] {
+		if common[off] {
+			steps = append(steps, off)
+		}
+	}
+
+	reduced := make([]Template, len(tmpls))
+
+	for i, tmpl := range tmpls {
+		before := len(tmpl)
+		for _, off := range tmpl {
+			if !chained(off, tmpl, steps) {
+				reduced[i] = append(reduced[i], off)
+			}
+		}
+		log.Infof("Minimal arcs in template: %v of %v (%.1f%%)",
+			len(reduced[i]), before, 100.0*float64(len(reduced[i]))/float64(before))
+	}
+
+	return reduced
+}
+
+// Is target reached by an offset of first followed by one or more steps,
+// inside the box of the origin and target
+func chained(target [3]int, first, steps Template) bool {
+
+	lo, hi := [3]int{}, target
+	for k := 0; k < 3; k++ {
+		if lo[k] > hi[k] {
+			lo[k], hi[k] = hi[k], lo[k]
+		}
+	}
+
+	nx, ny := hi[0]-lo[0]+1, hi[1]-lo[1]+1
+	box := func(p [3]int) int {
+		for k := 0; k < 3; k++ {
+			if p[k] < lo[k] || p[k] > hi[k] {
+				return -1
+			}
+		}
+		return (p[0] - lo[0]) + nx*((p[1]-lo[1])+ny*(p[2]-lo[2]))
+	}
+
+	seen := make([]bool, nx*ny*(hi[2]-lo[2]+1))
+	var stack [][3]int
+
+	for _, off := range first {
+		if off == target {
+			continue
+		} else if k := box(off); k >= 0 && !seen[k] {
+			seen[k] = true
+			stack = append(stack, off)
+		}
+	}
+
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, s := range steps {
+			q := [3]int{p[0] + s[0], p[1] + s[1], p[2] + s[2]}
+			if q == target {
+				return true
+			} else if k := box(q); k >= 0 && !seen[k] {
+				seen[k] = true
+				stack = append(stack, q)
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,104 @@
+package optimization
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The precedence of a small grid, with every block a candidate
+func minimalTestPrecedence(t *testing.T, prec Precedence, minimal bool) (*Parameters, *Precedence) {
+
+	ctx := &Parameters{Precedence: prec}
+	ctx.Input.Grid = Grid{NumX: 11, NumY: 9, NumZ: 6, SizX: 10, SizY: 15, SizZ: 8}
+	ctx.Precedence.Minimal = minimal
+
+	pg := &ctx.Input.Grid
+	ctx.Input.zones = make([]int, pg.gridCount())
+	for i := range ctx.Input.zones {
+		if i%pg.NumX < pg.NumX/2 {
+			ctx.Input.zones[i] = 1
+		}
+	}
+
+	mask := make([]bool, pg.gridCount())
+	for i := range mask {
+		mask[i] = true
+	}
+
+	if e := ctx.Precedence.init(ctx, mask); e != nil {
+		t.Fatal(e)
+	}
+
+	return ctx, &ctx.Precedence
+}
+
+// The minimal arcs must give every block the same cone, and so the same pits,
+// as the full template
+func TestMinimalPrecedence(t *testing.T) {
+
+	cases := []Precedence{
+		{Method: BENCH, Slope: 45, NumBenches: 1},
+		{Method: BENCH, Slope: 45, NumBenches: 4},
+		{Method: BENCH, Slope: 30, NumBenches: 3},
+		{Method: SECTORS, NumBenches: 3, Sectors: SlopeSectors{{0, 50}, {90, 35}, {200, 60}}},
+		{Method: ZONES, Slope: 55, NumBenches: 3, Zones: []SlopeZone{{Zone: 1, Slope: 40}}},
+	}
+
+	for k, c := range cases {
+
+		name := fmt.Sprintf("%v %v, %v benches", methodNames[c.Method], k, c.NumBenches)
+
+		t.Run(name, func(t *testing.T) {
+
+			ctx, full := minimalTestPrecedence(t, c, false)
+			_, minimal := minimalTestPrecedence(t, c, true)
+
+			var fullArcs, minimalArcs int
+			for i := range full.keys {
+				if full.keys[i] != MISSING {
+					fullArcs += len(full.defs[full.keys[i]])
+				}
+				if minimal.keys[i] != MISSING {
+					minimalArcs += len(minimal.defs[minimal.keys[i]])
+				}
+			}
+			if c.NumBenches > 1 && minimalArcs >= fullArcs {
+				t.Errorf("minimal arcs %v, full %v", minimalArcs, fullArcs)
+			}
+
+			pg := &ctx.Input.Grid
+			n := pg.gridCount()
+
+			for i := 0; i < n; i++ {
+				a, b := make([]bool, n), make([]bool, n)
+				a[i], b[i] = true, true
+				full.closure(a)
+				minimal.closure(b)
+				for j := range a {
+					if a[j] != b[j] {
+						t.Fatalf("cone of %v: %v is in the full one %v, the minimal one %v", pg.gridName(i), pg.gridName(j), a[j], b[j])
+					}
+				}
+			}
+
+			rng := rand.New(rand.NewSource(int64(c.NumBenches)))
+			for k := 0; k < 5; k++ {
+
+				data := make([]float64, n)
+				for i := range data {
+					data[i] = rng.NormFloat64()*10 - 4
+				}
+
+				a, _ := pseudoflowSolver(t, true, false)(data, full, 1e6)
+				b, _ := pseudoflowSolver(t, true, false)(data, minimal, 1e6)
+
+				for j := range a {
+					if a[j] != b[j] {
+						t.Fatalf("problem %v: %v is in the full pit %v, the minimal one %v", k, pg.gridName(j), a[j], b[j])
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package optimization
 
 import (
+	"bufio"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -12,11 +13,13 @@ import (
 
 type (
 	RunCtx struct {
-		TaskID     string
-		Notify     chan string
-		InputFile  string
-		OutputFile string
-		ParamFile  string
+		TaskID       string
+		Notify       chan string
+		InputFile    string
+		OutputFile   string
+		ParamFile    string
+		SolutionFile string
+		Realization  int
 	}
 )
 
@@ -34,10 +37,42 @@ func StartRead(opt RunCtx) error {
 	log.Infof("Begin reading input from %v", opt.InputFile)
 	notifyStatus(opt.Notify, "Reading input data")
 
-	if e := params.Input.initializeFromGzip(opt.InputFile); e != nil {
+	if e := params.initialize(opt.InputFile); e != nil {
 		return e
 	}
 
+	if params.Report.enabled() && !params.Shells.enabled() {
+		e := fmt.Errorf("ERROR: the pit by pit report needs shells")
+		log.Error(e)
+		return e
+	}
+
+	if params.Shells.enabled() {
+
+		shells, _, status := params.NestedShells(opt.Notify)
+
+		if status != 0 {
+			e := fmt.Errorf("Failed do optimization")
+			log.Error(e)
+			return e
+		}
+
+		if params.Economics.enabled() {
+			pits := make([][]bool, len(shells))
+			for r := range shells {
+				pits[r] = make([]bool, len(shells[r]))
+				for i, s := range shells[r] {
+					pits[r][i] = s != NO_SHELL
+				}
+			}
+			params.Economics.reportMetal(&params.Input, pits)
+		}
+
+		return writeOutput(opt.OutputFile, "Shell", len(shells), len(shells[0]), func(r, i int) int {
+			return shells[r][i]
+		})
+	}
+
 	selection, status := params.LG(opt.Notify)
 
 	if status != 0 {
@@ -46,54 +81,119 @@ func StartRead(opt RunCtx) error {
 		return e
 	}
 
+	if params.Economics.enabled() {
+		params.Economics.reportMetal(&params.Input, selection)
+	}
+
+	if len(params.Constraints.Lease) > 0 {
+		if e := params.leaseLoss(opt.Notify, selection); e != nil {
+			return e
+		}
+	}
+
+	if strings.HasSuffix(opt.OutputFile, ".sol") {
+		return writeSolFile(opt.OutputFile, selection)
+	}
+
+	return writeOutput(opt.OutputFile, "Pit", len(selection), len(selection[0]), func(r, i int) int {
+		if selection[r][i] {
+			return 1
+		}
+		return 0
+	})
+}
+
+// Write one value per block and realization, realizations one after the
+// other. The console output has a GEOEAS header.
+func writeOutput(outfile, name string, nReal, nData int, value func(r, i int) int) (e error) {
+
 	var writer io.Writer
 	var write_head bool
-	var doclose func() error
 
-	if len(opt.OutputFile) == 0 {
+	// The gzip writer then the file, closed in that order whatever the
+	// return, the first error kept
+	var closers []func() error
+	defer func() {
+		for _, c := range closers {
+			if ce := c(); ce != nil && e == nil {
+				e = fmt.Errorf("Failed to write output file %v: %v", outfile, ce)
+				log.Error(e)
+			}
+		}
+	}()
+
+	if len(outfile) == 0 {
 		writer = os.Stdout
 		write_head = true
 	} else {
 
-		file, e := os.Create(opt.OutputFile)
+		file, e := os.Create(outfile)
 		if e != nil {
-			e = fmt.Errorf("Failed to create output file %v: %v", opt.OutputFile, e)
+			e = fmt.Errorf("Failed to create output file %v: %v", outfile, e)
 			log.Error(e)
 			return e
 		}
-		defer file.Close()
 		writer = file
+		closers = append(closers, file.Close)
 
-		if strings.HasSuffix(opt.OutputFile, ".gz") {
+		if strings.HasSuffix(outfile, ".gz") {
 			zipwriter := gzip.NewWriter(writer)
-			doclose = zipwriter.Close
+			closers = append([]func() error{zipwriter.Close}, closers...)
 			writer = zipwriter
 		} else {
 			write_head = false
 		}
 	}
 
+	w := bufio.NewWriter(writer)
+
 	if write_head {
-		fmt.Fprintln(writer, "ultpit output")
-		fmt.Fprintln(writer, "1")
-		fmt.Fprintln(writer, "Pit")
+		fmt.Fprintln(w, "ultpit output")
+		fmt.Fprintln(w, "1")
+		fmt.Fprintln(w, name)
 	}
 
-	for _, row := range selection {
-		for _, v := range row {
-			if v {
-				fmt.Fprintln(writer, "1")
-			} else {
-				fmt.Fprintln(writer, "0")
-			}
+	for r := 0; r < nReal; r++ {
+		for i := 0; i < nData; i++ {
+			fmt.Fprintln(w, value(r, i))
 		}
 	}
 
-	if doclose != nil {
-		doclose()
+	if e = w.Flush(); e != nil {
+		e = fmt.Errorf("Failed to write output file %v: %v", outfile, e)
+		log.Error(e)
+	}
+
+	return e
+}
+
+// MineLib solutions hold a single realization
+func writeSolFile(outfile string, selection [][]bool) error {
+
+	if len(selection) != 1 {
+		e := fmt.Errorf("ERROR: a MineLib solution holds one realization, found %v", len(selection))
+		log.Error(e)
+		return e
+	}
+
+	file, e := os.Create(outfile)
+	if e != nil {
+		e = fmt.Errorf("Failed to create output file %v: %v", outfile, e)
+		log.Error(e)
+		return e
+	}
+
+	e = writeMinelibSol(file, selection[0])
+	if ce := file.Close(); e == nil {
+		e = ce
+	}
+
+	if e != nil {
+		e = fmt.Errorf("Failed to write output file %v: %v", outfile, e)
+		log.Error(e)
 	}
 
-	return nil
+	return e
 }
 
 func notifyStatus(ch chan<- string, status string) {
@@ -2,6 +2,7 @@ package optimization
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/cihub/seelog"
 )
@@ -11,6 +12,10 @@ type (
 		Input        Data `json:"input"`
 		Precedence   `json:"precedence"`
 		ConfigParams `json:"optimization"`
+		Shells       ShellParams      `json:"shells"`
+		Report       ReportParams     `json:"report"`
+		Economics    EconomicParams   `json:"economics"`
+		Constraints  ConstraintParams `json:"constraints"`
 	}
 )
 
@@ -22,47 +27,24 @@ func (ctx *Parameters) LG(ch chan<- string) ([][]bool, int) {
 	log.Infof("Number of realizations: %v", nReal)
 	log.Infof("Number of rows: %v", nData)
 
-	log.Info("Begin creating naive mask")
-	notifyStatus(ch, "Creating naive mask")
-	mask := ctx.generateMask()
-
-	log.Info("Begin creating precedence")
-	notifyStatus(ch, "Creating precedence")
-	if ctx.Precedence.init(ctx, mask) != nil {
-		return nil, -1
-	}
-
-	//--------------------------------------------------
-
-	log.Info("Updating mask")
-	notifyStatus(ch, "Updating mask")
-	for i := 0; i < nData; i++ {
-		if mask[i] {
-			if key := ctx.Precedence.keys[i]; key != MISSING {
-				for _, off := range ctx.Precedence.defs[key] {
-					mask[i+off] = true
-				}
-			}
-		}
-	}
-
-	//--------------------------------------------------
-
-	log.Info("Begin compressing")
-	notifyStatus(ch, "Compressing")
-
-	var condensedEBV Data
-	var condensedPre Precedence
-
-	if !compressEverything(mask, &ctx.Input, &ctx.Precedence, &condensedEBV, &condensedPre) {
-		log.Info("ERROR: Compressing everything failed")
-		return nil, 1
+	mask, condensedEBV, condensedPre, status := ctx.condense(ch)
+	if status != 0 {
+		return nil, status
 	}
 
 	// allocate the condensedSolutions
 	rows := len(condensedEBV.Ebv)
 	solutions := make([][]bool, rows)
 
+	// The block of every condensed index
+	blocks := make([]int, 0, len(condensedEBV.Ebv[0]))
+	for i, v := range mask {
+		if v {
+			blocks = append(blocks, i)
+		}
+	}
+	block := func(i int) int { return blocks[i] }
+
 	//--------------------------------------------------
 	// Solve-em
 
@@ -70,13 +52,9 @@ func (ctx *Parameters) LG(ch chan<- string) ([][]bool, int) {
 	notifyStatus(ch, "Optimizing")
 
 	for r := 0; r < nReal; r++ {
-		engine, e := getEngine(&ctx.EngineParam)
-		if engine == nil {
-			log.Info("Error: failed initializing optimization engine: %v", e)
-			return nil, 1
-		}
+		data := condensedEBV.engineValues(condensedEBV.Ebv[r], nil)
 
-		row, status := engine.computeSolution(ch, condensedEBV.Ebv[r], &condensedPre)
+		row, status := ctx.solve(ch, fmt.Sprintf("realization %v", r), data, condensedPre, block)
 
 		if status != 0 {
 			return nil, status
@@ -127,18 +105,128 @@ func (ctx *Parameters) LG(ch chan<- string) ([][]bool, int) {
 	log.Info("Fixing air blocks")
 	notifyStatus(ch, "Fixing air blocks")
 	for r := 0; r < nReal; r++ {
-		for i := 0; i < nData; i++ {
-			if selection[r][i] {
-				if key := ctx.Precedence.keys[i]; key != MISSING {
-					for _, off := range ctx.Precedence.defs[key] {
-						selection[r][i+off] = true
-					}
-				}
+		ctx.Precedence.closure(selection[r])
+		ctx.Input.clearAir(selection[r])
+	}
+
+	return selection, 0
+}
+
+// Solve a condensed problem with a new engine, comparing its pit with the
+// other engines' if asked. block gives the grid index of a block of the
+// problem.
+func (ctx *Parameters) solve(ch chan<- string, name string, data []float64, pre *Precedence, block func(i int) int) ([]bool, int) {
+
+	start := time.Now()
+
+	pit, status := ctx.solveWith(ch, ctx.EngineType, name, data, pre, block)
+	if status != 0 || len(ctx.Compare) == 0 {
+		return pit, status
+	}
+
+	if ctx.compare(ch, name, data, pre, pit, time.Since(start), block) != 0 {
+		return nil, 1
+	}
+
+	return pit, 0
+}
+
+// Solve with a new engine of the type, verifying the pit if asked
+func (ctx *Parameters) solveWith(ch chan<- string, engineType int, name string, data []float64, pre *Precedence, block func(i int) int) ([]bool, int) {
+
+	param := ctx.ConfigParams
+	param.EngineType = engineType
+
+	engine, e := getEngine(&param)
+	if engine == nil {
+		log.Errorf("Error: failed initializing optimization engine %v: %v", engineType, e)
+		return nil, 1
+	}
+
+	pit, status := engine.computeSolution(ch, data, pre)
+	if status != 0 || !ctx.Verify {
+		return pit, status
+	}
+
+	if ctx.verify(engine, name, data, pre, pit, block) != nil {
+		return nil, 1
+	}
+
+	return pit, 0
+}
+
+// Build the mask, the precedence and the problem condensed to the masked
+// blocks, as handed to the engines.
+func (ctx *Parameters) condense(ch chan<- string) ([]bool, *Data, *Precedence, int) {
+
+	log.Info("Begin creating naive mask")
+	notifyStatus(ch, "Creating naive mask")
+	mask := ctx.generateMask()
+
+	var include, exclude []bool
+	if ctx.Constraints.enabled() {
+		var e error
+		if include, exclude, e = ctx.constrainMask(mask); e != nil {
+			return nil, nil, nil, -1
+		}
+	}
+
+	log.Info("Begin creating precedence")
+	notifyStatus(ch, "Creating precedence")
+	if ctx.Precedence.init(ctx, mask) != nil {
+		return nil, nil, nil, -1
+	}
+
+	if ctx.Constraints.enabled() {
+		if ctx.constrainPrecedence(mask, include, exclude) != nil {
+			return nil, nil, nil, -1
+		}
+	}
+
+	//--------------------------------------------------
+
+	log.Info("Updating mask")
+	notifyStatus(ch, "Updating mask")
+	ctx.Precedence.closure(mask)
+
+	//--------------------------------------------------
+
+	log.Info("Begin compressing")
+	notifyStatus(ch, "Compressing")
+
+	condensedEBV := new(Data)
+	condensedPre := new(Precedence)
+
+	if !compressEverything(mask, &ctx.Input, &ctx.Precedence, condensedEBV, condensedPre) {
+		log.Info("ERROR: Compressing everything failed")
+		return nil, nil, nil, 1
+	}
+
+	// The included blocks, by condensed index
+	if include != nil {
+		condensedEBV.forced = make([]bool, 0, len(condensedEBV.Ebv[0]))
+		for i, v := range mask {
+			if v {
+				condensedEBV.forced = append(condensedEBV.forced, include[i])
 			}
 		}
 	}
 
-	return selection, 0
+	return mask, condensedEBV, condensedPre, 0
+}
+
+// The values handed to the engines for the condensed blocks of subset, all
+// of them if nil, the included blocks made worth mining
+func (condensed *Data) engineValues(data []float64, subset []int) []float64 {
+
+	forced := condensed.forced
+	if forced == nil {
+		return data
+	} else if subset == nil {
+		return forceValues(data, func(i int) bool { return forced[i] })
+	}
+
+	return forceValues(data, func(i int) bool { return forced[subset[i]] })
 }
 
 func (ctx *Parameters) generateMask() []bool {
@@ -146,6 +234,34 @@ func (ctx *Parameters) generateMask() []bool {
 	n := ctx.Input.Grid.gridCount()
 	mask := make([]bool, n)
 
+	// Explicit problems have no air and every block is a candidate
+	if ctx.Input.Type == MINELIB {
+		for i := range mask {
+			mask[i] = true
+		}
+		log.Infof("Count of values in mask: %v", n)
+		return mask
+	}
+
+	// The air is known, every other block with a value is a candidate
+	if ctx.Input.air != nil {
+		cnt := 0
+		for i := 0; i < n; i++ {
+			if ctx.Input.air[i] {
+				continue
+			}
+			for _, layer := range ctx.Input.Ebv {
+				if layer[i] >= 0 {
+					mask[i] = true
+					cnt++
+					break
+				}
+			}
+		}
+		log.Infof("Count of values in mask: %v", cnt)
+		return mask
+	}
+
 	for i := 0; i < n; i++ {
 		// If one layer's value is greater than 0,then mask -> true
 		for _, layer := range ctx.Input.Ebv {
@@ -0,0 +1,102 @@
+package optimization
+
+import (
+	"fmt"
+	"math"
+
+	log "github.com/cihub/seelog"
+)
+
+// The classic block patterns, the predecessors on the bench above whatever
+// the slope. 1:5 is the block above and its 4 neighbours, 1:9 the block
+// above and its 8 neighbours. The knight's move alternates 1:5 and 1:9
+// from bench to bench, so two benches up a block depends on the 5 x 5
+// square less its corners, the knight's moves.
+
+var (
+	onePlusFour = Template{
+		{0, -1, 1},
+		{-1, 0, 1}, {0, 0, 1}, {1, 0, 1},
+		{0, 1, 1},
+	}
+	onePlusEight = Template{
+		{-1, -1, 1}, {0, -1, 1}, {1, -1, 1},
+		{-1, 0, 1}, {0, 0, 1}, {1, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+	}
+
+	// The names of the methods, for messages
+	methodNames = map[int]string{
+		BENCH:     "bench",
+		EXPLICIT:  "explicit",
+		SECTORS:   "sectors",
+		ZONES:     "zones",
+		ONE_FIVE:  "1:5",
+		ONE_NINE:  "1:9",
+		KNIGHT:    "knight's move",
+		ELEVATION: "elevation",
+	}
+)
+
+// The valid methods, for messages
+func methodList() string {
+	s := ""
+	for m := BENCH; m <= len(methodNames); m++ {
+		if len(s) > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%v (%v)", m, methodNames[m])
+	}
+	return s
+}
+
+// The error of an unknown method
+func methodError(method int) error {
+	if method == 0 {
+		return fmt.Errorf("ERROR: precedence method is missing, must be one of %v", methodList())
+	}
+	return fmt.Errorf("ERROR: precedence method must be one of %v. Supplied: %v", methodList(), method)
+}
+
+func (prec *Precedence) checkPattern() error {
+
+	name := methodNames[prec.Method]
+
+	if prec.Slope != 0 || len(prec.Sectors) > 0 || len(prec.Zones) > 0 || len(prec.Profile) > 0 {
+		return fmt.Errorf("ERROR: the %v pattern takes no slope, sectors, zones or profile", name)
+	} else if prec.NumBenches != 0 {
+		return fmt.Errorf("ERROR: the %v pattern takes no num_benches, it only looks at the bench above", name)
+	}
+	return nil
+}
+
+func (prec *Precedence) genPattern(ctx *Parameters, mask []bool) {
+
+	pg := &ctx.Input.Grid
+
+	// Along the axes and the diagonal, over one bench for 1:5 and 1:9 or
+	// over two for the knight's move, which also reaches two blocks along an
+	// axis and one across
+	deg := func(rise, run float64) float64 { return math.Atan2(rise, run) * 180.0 / math.Pi }
+	diag := math.Hypot(pg.SizX, pg.SizY)
+
+	switch prec.Method {
+	case ONE_FIVE:
+		log.Infof("1:5 pattern, slopes x: %.1f, y: %.1f, diagonal: %.1f",
+			deg(pg.SizZ, pg.SizX), deg(pg.SizZ, pg.SizY), deg(2*pg.SizZ, diag))
+		prec.applyTemplate(pg, mask, onePlusFour)
+
+	case ONE_NINE:
+		log.Infof("1:9 pattern, slopes x: %.1f, y: %.1f, diagonal: %.1f",
+			deg(pg.SizZ, pg.SizX), deg(pg.SizZ, pg.SizY), deg(pg.SizZ, diag))
+		prec.applyTemplate(pg, mask, onePlusEight)
+
+	case KNIGHT:
+		log.Infof("Knight's move pattern, slopes x: %.1f, y: %.1f, diagonal: %.1f, knight's move x: %.1f, y: %.1f",
+			deg(pg.SizZ, pg.SizX), deg(pg.SizZ, pg.SizY), deg(2*pg.SizZ, diag),
+			deg(2*pg.SizZ, math.Hypot(2*pg.SizX, pg.SizY)), deg(2*pg.SizZ, math.Hypot(pg.SizX, 2*pg.SizY)))
+		prec.applyTemplates(pg, mask, []Template{onePlusFour, onePlusEight}, func(loc int) int {
+			return pg.gridIz(loc) % 2
+		})
+	}
+}
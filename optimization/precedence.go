@@ -1,6 +1,7 @@
 package optimization
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 
@@ -12,14 +13,33 @@ type (
 		Method     int     `json:"method"`
 		Slope      float64 `json:"slope"`
 		NumBenches int     `json:"num_benches"`
+		// Slopes varying with the azimuth
+		Sectors SlopeSectors `json:"sectors"`
+		// Slopes of the geotechnical zones
+		Zones []SlopeZone `json:"zones"`
+		// Slopes by elevation
+		Profile []SlopeBand `json:"profile"`
+		// Explicit arcs, the file and its format (prec or csv)
+		File   string `json:"file"`
+		Format string `json:"format"`
+		// Generate the minimal arc set of the bench templates
+		Minimal bool `json:"minimal"`
 		//-------------------------------------
-		keys []int
-		defs [][]int
+		keys     []int
+		defs     [][]int
+		defIndex map[string]int
 	}
 )
 
 const (
 	BENCH       = 1
+	EXPLICIT    = 2
+	SECTORS     = 3
+	ZONES       = 4
+	ONE_FIVE    = 5
+	ONE_NINE    = 6
+	KNIGHT      = 7
+	ELEVATION   = 8
 	MISSING     = -1
 	MIN_BENCHES = 1
 	MAX_BENCHES = 99
@@ -31,37 +51,84 @@ func (prec *Precedence) init(ctx *Parameters, mask []bool) error {
 
 	var e error
 
-	if prec.Method != BENCH {
-		e = fmt.Errorf("Invalid Precedence method")
-	} else if prec.NumBenches < MIN_BENCHES || prec.NumBenches > MAX_BENCHES {
-		e = fmt.Errorf(
-			"ERROR: benches must be between %v and %v. Supplied: %v",
-			MIN_BENCHES, MAX_BENCHES, prec.NumBenches,
-		)
-	} else if prec.Slope < MIN_SLOPE || prec.Slope > MAX_SLOPE {
-		e = fmt.Errorf(
-			"ERROR: slope must be between %v and %v. Supplied: %v",
-			MIN_SLOPE, MAX_SLOPE, prec.Slope,
-		)
-	} else if len(mask) != ctx.Input.Grid.gridCount() {
+	if len(mask) != ctx.Input.Grid.gridCount() {
 		e = fmt.Errorf("ERROR: mask size does not equal grid size")
+	} else {
+		switch prec.Method {
+		case BENCH:
+			if e = prec.checkBench(); e == nil {
+				prec.genBench(ctx, mask)
+			}
+		case SECTORS:
+			if e = prec.checkSectors(); e == nil {
+				prec.genSectors(ctx, mask)
+			}
+		case ZONES:
+			if e = prec.checkZones(ctx); e == nil {
+				prec.genZones(ctx, mask)
+			}
+		case ELEVATION:
+			if e = prec.checkElevation(); e == nil {
+				prec.genElevation(ctx, mask)
+			}
+		case ONE_FIVE, ONE_NINE, KNIGHT:
+			if e = prec.checkPattern(); e == nil {
+				prec.genPattern(ctx, mask)
+			}
+		case EXPLICIT:
+			// From the file, or loaded along with a MineLib input
+			if len(prec.File) > 0 {
+				e = prec.readExplicit(&ctx.Input.Grid)
+			} else if len(prec.keys) != len(mask) {
+				e = fmt.Errorf("ERROR: explicit precedence requires a file or a MineLib input")
+			}
+		default:
+			e = methodError(prec.Method)
+		}
 	}
 
 	if e != nil {
 		log.Error(e)
 		return e
 	} else {
-		prec.genBench(ctx, mask)
 		prec.logExtraInfo()
 		return nil
 	}
 }
 
+func (prec *Precedence) checkBench() error {
+	if prec.NumBenches < MIN_BENCHES || prec.NumBenches > MAX_BENCHES {
+		return fmt.Errorf(
+			"ERROR: benches must be between %v and %v. Supplied: %v",
+			MIN_BENCHES, MAX_BENCHES, prec.NumBenches,
+		)
+	} else if prec.Slope < MIN_SLOPE || prec.Slope > MAX_SLOPE {
+		return fmt.Errorf(
+			"ERROR: slope must be between %v and %v. Supplied: %v",
+			MIN_SLOPE, MAX_SLOPE, prec.Slope,
+		)
+	}
+	return nil
+}
+
 func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 
+	slope := prec.Slope
 	pg := &ctx.Input.Grid
 
-	theta := prec.Slope * math.Pi / 180.0
+	tmpl := prec.benchTemplate(pg, slope, func(azimuth float64) float64 { return slope })
+	prec.applyTemplate(pg, mask, tmpl)
+}
+
+// Template is the offsets, in blocks, from a block to its predecessors
+type Template [][3]int
+
+// Generate the template of a cone whose slope depends on the azimuth
+// (degrees clockwise from north, the y axis). flattest is the lowest slope
+// of slopeAt, which sizes the template.
+func (prec *Precedence) benchTemplate(pg *Grid, flattest float64, slopeAt func(azimuth float64) float64) Template {
+
+	theta := flattest * math.Pi / 180.0
 	maxVert := float64(prec.NumBenches) * pg.SizZ
 	maxRadius := maxVert / math.Tan(theta)
 
@@ -80,6 +147,17 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 	log.Infof("  y: %v", yblocks)
 	log.Infof("  z: %v", zblocks)
 
+	// The tangent of the slope of every column of the template
+	tans := make([][]float64, yblocks)
+	for y := 0; y < yblocks; y++ {
+		tans[y] = make([]float64, xblocks)
+		for x := 0; x < xblocks; x++ {
+			xloc := float64(x-xcenter) * pg.SizX
+			yloc := float64(y-ycenter) * pg.SizY
+			tans[y][x] = math.Tan(slopeAt(azimuth(xloc, yloc)) * math.Pi / 180.0)
+		}
+	}
+
 	//----------------------------------------
 
 	offTemplate := make([][][]bool, zblocks)
@@ -88,8 +166,6 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 	for z := 0; z < zblocks; z++ {
 
 		zloc := float64(z+1) * pg.SizZ
-		rad := zloc / math.Tan(theta)
-		rad2 := rad * rad
 
 		dimy := make([][]bool, yblocks)
 
@@ -105,7 +181,9 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 				xloc := float64(x-xcenter) * pg.SizX
 				xloc2 := xloc * xloc
 
-				dimx[x] = (xloc2+yloc2 <= rad2)
+				rad := zloc / tans[y][x]
+
+				dimx[x] = (xloc2+yloc2 <= rad*rad)
 			}
 
 			dimy[y] = dimx
@@ -134,7 +212,7 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 
 	//---------------------------------------------------------------------------
 
-	var firstDef, ixs, iys, izs []int
+	var tmpl Template
 
 	for z := 0; z < zblocks; z++ {
 		zl := z + 1
@@ -143,17 +221,47 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 			for x := 0; x < xblocks; x++ {
 				xl := x - xblock
 				if offTemplate[z][y][x] {
-					n := pg.gridIndex(xl, yl, zl)
-					firstDef = append(firstDef, n)
-					ixs = append(ixs, xl)
-					iys = append(iys, yl)
-					izs = append(izs, zl)
+					tmpl = append(tmpl, [3]int{xl, yl, zl})
 				}
 			}
 		}
 	}
 
-	prec.addToDefs(firstDef)
+	return tmpl
+}
+
+// The azimuth, in degrees clockwise from north, of an horizontal offset
+func azimuth(x, y float64) float64 {
+	az := math.Atan2(x, y) * 180.0 / math.Pi
+	if az < 0 {
+		az += 360.0
+	}
+	return az
+}
+
+// Set the keys of the masked blocks, and of the blocks they depend on, from
+// the template. Offsets leaving the grid are dropped.
+func (prec *Precedence) applyTemplate(pg *Grid, mask []bool, tmpl Template) {
+	prec.applyTemplates(pg, mask, []Template{tmpl}, func(loc int) int { return 0 })
+}
+
+// Like applyTemplate, pick choosing the template of every block
+func (prec *Precedence) applyTemplates(pg *Grid, mask []bool, tmpls []Template, pick func(loc int) int) {
+
+	if prec.Minimal {
+		tmpls = minimalTemplates(tmpls)
+	}
+
+	for _, tmpl := range tmpls {
+
+		var firstDef []int
+
+		for _, off := range tmpl {
+			firstDef = append(firstDef, pg.gridIndex(off[0], off[1], off[2]))
+		}
+
+		prec.addToDefs(firstDef)
+	}
 
 	//---------------------------------------------------------------------------
 
@@ -178,14 +286,14 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 
 				var thisdef []int
 
-				for i := range ixs {
+				for _, off := range tmpls[pick(loc)] {
 
-					xl := x + ixs[i]
-					yl := y + iys[i]
-					zl := z + izs[i]
+					xl := x + off[0]
+					yl := y + off[1]
+					zl := z + off[2]
 
 					if in(xl, pg.NumX) && in(yl, pg.NumY) && in(zl, pg.NumZ) {
-						ind := pg.gridIndex(ixs[i], iys[i], izs[i])
+						ind := pg.gridIndex(off[0], off[1], off[2])
 						thisdef = append(thisdef, ind)
 						hit[loc+ind] = true
 					}
@@ -204,30 +312,74 @@ func (prec *Precedence) genBench(ctx *Parameters, mask []bool) {
 // Try to add the given definition to the defs, return the key
 func (prec *Precedence) addToDefs(defs []int) int {
 
+	if prec.defIndex == nil {
+		prec.defIndex = make(map[string]int, len(prec.defs))
+		for idx, array := range prec.defs {
+			prec.defIndex[defKey(array)] = idx
+		}
+	}
+
 	// Check for duplicates
-	for idx, array := range prec.defs {
+	key := defKey(defs)
+	if idx, ok := prec.defIndex[key]; ok {
+		return idx
+	}
 
-		if len(array) != len(defs) {
-			continue
-		}
+	prec.defs = append(prec.defs, defs)
+	prec.defIndex[key] = len(prec.defs) - 1
 
-		same := true
+	return len(prec.defs) - 1
+}
 
-		for i := range array {
-			if array[i] != defs[i] {
-				same = false
-				break
+// A map key identifying a definition
+func defKey(defs []int) string {
+	buf := make([]byte, len(defs)*binary.MaxVarintLen64)
+	n := 0
+	for _, v := range defs {
+		n += binary.PutVarint(buf[n:], int64(v))
+	}
+	return string(buf[:n])
+}
+
+// The arcs, as block and predecessor, not respected by a selection
+func (prec *Precedence) violations(selection []bool) (arcs [][2]int) {
+	for i, v := range selection {
+		if v {
+			if key := prec.keys[i]; key != MISSING {
+				for _, off := range prec.defs[key] {
+					if !selection[i+off] {
+						arcs = append(arcs, [2]int{i, i + off})
+					}
+				}
 			}
 		}
+	}
+	return
+}
 
-		if same {
-			return idx
+// Add to the selection every block it depends on. Arcs may point anywhere
+// in the grid, so the predecessors are followed until none is left.
+func (prec *Precedence) closure(selection []bool) {
+
+	var stack IntStack
+
+	for i, v := range selection {
+		if v {
+			stack.push(i)
 		}
 	}
 
-	prec.defs = append(prec.defs, defs)
-
-	return len(prec.defs) - 1
+	for stack.notEmpty() {
+		i := stack.pop()
+		if key := prec.keys[i]; key != MISSING {
+			for _, off := range prec.defs[key] {
+				if j := i + off; !selection[j] {
+					selection[j] = true
+					stack.push(j)
+				}
+			}
+		}
+	}
 }
 
 // count the trues in the template
@@ -0,0 +1,427 @@
+package optimization
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// Hochbaum's pseudoflow on the closure graph, in memory. The source and
+// sink arcs are saturated up front, so every block starts as a root with
+// its value as excess: strong if positive, weak otherwise. A strong tree
+// looks for a residual arc from one of its nodes to a node one label lower
+// and, found, hangs from it and pushes its excess up to the root of the
+// other tree, splitting at the arcs that can't take it all. Otherwise the
+// nodes of the root's label are relabeled. The precedence arcs are infinite,
+// block to predecessor, and only carry flow back down.
+//
+// Labels never decrease along a residual arc by more than one and the
+// deficits only sit at weak roots of label 0, so a tree above an empty
+// label can't reach them and is lifted to the top label, numNodes, at once.
+// At the end the blocks of the top label are the source set of the min cut.
+
+type (
+	pseudoflow struct {
+		numNodes int
+		lowest   bool
+		fifo     bool
+
+		// Arcs from a block to its predecessors, by block, and the arcs
+		// into every block
+		first   []int32
+		head    []int32
+		tail    []int32
+		inFirst []int32
+		inArcs  []int32
+		flow    []int64
+
+		excess   []int64
+		label    []int32
+		parent   []int32
+		toParent []int32
+		child    []int32
+		next     []int32
+		prev     []int32
+		current  []int32
+
+		labelCount []int
+		buckets    []rootBucket
+		rootNext   []int32
+		inBucket   []bool
+		top        int
+		bottom     int
+
+		values   []int64
+		merges   int
+		relabels int
+	}
+
+	// The strong roots of a label, in a list
+	rootBucket struct {
+		start, end int32
+	}
+)
+
+// Build the closure graph of data and pre, the values times precision as
+// capacities
+func newPseudoflow(data []float64, pre *Precedence, precision float64, lowest, fifo bool) *pseudoflow {
+
+	n := len(data)
+
+	pf := &pseudoflow{
+		numNodes: n,
+		lowest:   lowest,
+		fifo:     fifo,
+		first:    make([]int32, n+1),
+		inFirst:  make([]int32, n+1),
+	}
+
+	for i := 0; i < n; i++ {
+		pf.first[i] = int32(len(pf.head))
+		if key := pre.keys[i]; key != MISSING {
+			for _, off := range pre.defs[key] {
+				pf.head = append(pf.head, int32(i+off))
+				pf.tail = append(pf.tail, int32(i))
+			}
+		}
+	}
+	pf.first[n] = int32(len(pf.head))
+	pf.flow = make([]int64, len(pf.head))
+
+	// The arcs into every block
+	for _, h := range pf.head {
+		pf.inFirst[h+1]++
+	}
+	for i := 0; i < n; i++ {
+		pf.inFirst[i+1] += pf.inFirst[i]
+	}
+	pf.inArcs = make([]int32, len(pf.head))
+	fill := append([]int32(nil), pf.inFirst[:n]...)
+	for a, h := range pf.head {
+		pf.inArcs[fill[h]] = int32(a)
+		fill[h]++
+	}
+
+	pf.excess = make([]int64, n)
+	pf.values = make([]int64, n)
+	pf.label = make([]int32, n)
+	pf.parent = make([]int32, n)
+	pf.toParent = make([]int32, n)
+	pf.child = make([]int32, n)
+	pf.next = make([]int32, n)
+	pf.prev = make([]int32, n)
+	pf.current = make([]int32, n)
+	pf.rootNext = make([]int32, n)
+	pf.inBucket = make([]bool, n)
+	pf.labelCount = make([]int, n+1)
+	pf.buckets = make([]rootBucket, n+1)
+	pf.bottom = n + 1
+
+	for l := range pf.buckets {
+		pf.buckets[l] = rootBucket{NOTHING, NOTHING}
+	}
+
+	// Saturate the source and sink arcs
+	for i, v := range data {
+		c := dimacsCapacity(v, precision)
+		if v < 0 {
+			c = -c
+		}
+		pf.excess[i] = c
+		pf.values[i] = c
+		pf.parent[i] = NOTHING
+		pf.toParent[i] = NOTHING
+		pf.child[i] = NOTHING
+		pf.next[i] = NOTHING
+		pf.prev[i] = NOTHING
+		if c > 0 {
+			pf.label[i] = 1
+			pf.addRoot(int32(i))
+		}
+		pf.labelCount[pf.label[i]]++
+	}
+
+	return pf
+}
+
+// Add a strong root to the bucket of its label
+func (pf *pseudoflow) addRoot(r int32) {
+
+	if pf.inBucket[r] {
+		return
+	}
+	pf.inBucket[r] = true
+
+	l := int(pf.label[r])
+	b := &pf.buckets[l]
+
+	if pf.fifo {
+		pf.rootNext[r] = NOTHING
+		if b.start == NOTHING {
+			b.start = r
+		} else {
+			pf.rootNext[b.end] = r
+		}
+		b.end = r
+	} else {
+		pf.rootNext[r] = b.start
+		b.start = r
+	}
+
+	if l > pf.top {
+		pf.top = l
+	}
+	if l < pf.bottom {
+		pf.bottom = l
+	}
+}
+
+// The next strong root to process, by highest or lowest label
+func (pf *pseudoflow) nextRoot() int32 {
+
+	for {
+		var l int
+		if pf.lowest {
+			for pf.bottom < pf.numNodes && pf.buckets[pf.bottom].start == NOTHING {
+				pf.bottom++
+			}
+			if pf.bottom >= pf.numNodes {
+				return NOTHING
+			}
+			l = pf.bottom
+		} else {
+			for pf.top >= 0 && pf.buckets[pf.top].start == NOTHING {
+				pf.top--
+			}
+			if pf.top < 0 {
+				return NOTHING
+			}
+			l = pf.top
+		}
+
+		b := &pf.buckets[l]
+		r := b.start
+		b.start = pf.rootNext[r]
+		pf.inBucket[r] = false
+
+		if pf.parent[r] == NOTHING && pf.excess[r] > 0 && int(pf.label[r]) == l {
+			return r
+		}
+	}
+}
+
+func (pf *pseudoflow) addChild(p, c int32) {
+	pf.parent[c] = p
+	pf.prev[c] = NOTHING
+	pf.next[c] = pf.child[p]
+	if pf.child[p] != NOTHING {
+		pf.prev[pf.child[p]] = c
+	}
+	pf.child[p] = c
+}
+
+func (pf *pseudoflow) removeChild(c int32) {
+	p := pf.parent[c]
+	if pf.prev[c] != NOTHING {
+		pf.next[pf.prev[c]] = pf.next[c]
+	} else {
+		pf.child[p] = pf.next[c]
+	}
+	if pf.next[c] != NOTHING {
+		pf.prev[pf.next[c]] = pf.prev[c]
+	}
+	pf.parent[c] = NOTHING
+	pf.next[c] = NOTHING
+	pf.prev[c] = NOTHING
+}
+
+// A residual arc from u to a node of label target, from the current arc on
+func (pf *pseudoflow) admissible(u int32, target int32) (int32, int32) {
+
+	out := pf.first[u+1] - pf.first[u]
+	deg := out + pf.inFirst[u+1] - pf.inFirst[u]
+
+	for k := pf.current[u]; k < deg; k++ {
+		var a, w int32
+		if k < out {
+			a = pf.first[u] + k
+			w = pf.head[a]
+		} else {
+			a = pf.inArcs[pf.inFirst[u]+k-out]
+			if pf.flow[a] == 0 {
+				continue
+			}
+			w = pf.tail[a]
+		}
+		if pf.label[w] == target {
+			pf.current[u] = k
+			return a, w
+		}
+	}
+
+	pf.current[u] = deg
+	return NOTHING, NOTHING
+}
+
+// Hang the tree of u from w by arc a, u becoming the child of w
+func (pf *pseudoflow) merge(u, w, a int32) {
+
+	cur, newParent, arc := u, w, a
+
+	for {
+		oldParent, oldArc := pf.parent[cur], pf.toParent[cur]
+		if oldParent != NOTHING {
+			pf.removeChild(cur)
+		}
+		pf.addChild(newParent, cur)
+		pf.toParent[cur] = arc
+		if oldParent == NOTHING {
+			return
+		}
+		newParent, cur, arc = cur, oldParent, oldArc
+	}
+}
+
+// Push the excess of r up to its root. An arc that can't take it all is
+// split, the rest staying with the child as a new strong root.
+func (pf *pseudoflow) push(r int32) {
+
+	cur := r
+
+	for pf.excess[cur] > 0 && pf.parent[cur] != NOTHING {
+
+		p, a := pf.parent[cur], pf.toParent[cur]
+		amount := pf.excess[cur]
+
+		if pf.tail[a] == cur {
+			pf.flow[a] += amount
+		} else if pf.flow[a] >= amount {
+			pf.flow[a] -= amount
+		} else {
+			amount = pf.flow[a]
+			pf.flow[a] = 0
+			pf.removeChild(cur)
+			pf.toParent[cur] = NOTHING
+			pf.excess[cur] -= amount
+			pf.excess[p] += amount
+			pf.addRoot(cur)
+			cur = p
+			continue
+		}
+
+		pf.excess[cur] = 0
+		pf.excess[p] += amount
+		cur = p
+	}
+
+	if pf.parent[cur] == NOTHING && pf.excess[cur] > 0 {
+		pf.addRoot(cur)
+	}
+}
+
+// Move every node of the tree of r to label l
+func (pf *pseudoflow) lift(r int32, l int32) {
+
+	stack := []int32{r}
+
+	for len(stack) > 0 {
+		u := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		pf.labelCount[pf.label[u]]--
+		pf.label[u] = l
+		pf.labelCount[l]++
+
+		for c := pf.child[u]; c != NOTHING; c = pf.next[c] {
+			stack = append(stack, c)
+		}
+	}
+}
+
+// Merge the tree of r with another one, or relabel its nodes of r's label
+func (pf *pseudoflow) processRoot(r int32) {
+
+	l := pf.label[r]
+	stack := []int32{r}
+	var component []int32
+
+	for len(stack) > 0 {
+		u := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		component = append(component, u)
+
+		if l > 0 {
+			if a, w := pf.admissible(u, l-1); a != NOTHING {
+				pf.merges++
+				pf.merge(u, w, a)
+				pf.push(r)
+				return
+			}
+		}
+
+		for c := pf.child[u]; c != NOTHING; c = pf.next[c] {
+			if pf.label[c] == l {
+				stack = append(stack, c)
+			}
+		}
+	}
+
+	pf.relabels++
+
+	for _, u := range component {
+		pf.labelCount[l]--
+		pf.label[u] = l + 1
+		pf.labelCount[l+1]++
+		pf.current[u] = 0
+	}
+
+	if int(l)+1 < pf.numNodes {
+		pf.addRoot(r)
+	}
+}
+
+// Run until every strong tree is at the top label
+func (pf *pseudoflow) solve() {
+
+	n := int32(pf.numNodes)
+
+	for r := pf.nextRoot(); r != NOTHING; r = pf.nextRoot() {
+		if l := pf.label[r]; l > 0 && pf.labelCount[l-1] == 0 {
+			pf.lift(r, n)
+		} else {
+			pf.processRoot(r)
+		}
+	}
+
+	log.Debugf("Pseudoflow merges: %v, relabels: %v", pf.merges, pf.relabels)
+}
+
+// The blocks in the source set of the min cut
+func (pf *pseudoflow) sourceSet() []bool {
+	solution := make([]bool, pf.numNodes)
+	for i, l := range pf.label {
+		solution[i] = int(l) >= pf.numNodes
+	}
+	return solution
+}
+
+// The max flow in capacity units, from the flows of the arcs. The source
+// arcs are saturated, the excess left in the blocks goes back to the source.
+func (pf *pseudoflow) flowValue() int64 {
+
+	excess := append([]int64(nil), pf.values...)
+	for a, f := range pf.flow {
+		excess[pf.tail[a]] -= f
+		excess[pf.head[a]] += f
+	}
+
+	var value int64
+	for i, v := range pf.values {
+		if v > 0 {
+			value += v
+		}
+		if excess[i] > 0 {
+			value -= excess[i]
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,47 @@
+package optimization
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The pseudoflow variants, by lowest label and fifo buckets
+var pseudoflowVariants = []struct {
+	lowest, fifo bool
+}{
+	{false, false},
+	{false, true},
+	{true, false},
+	{true, true},
+}
+
+func pseudoflowSolver(t *testing.T, lowest, fifo bool) closureSolver {
+	return func(data []float64, pre *Precedence, precision float64) ([]bool, int64) {
+		solver := &DimacsSolver{Precision: precision, LowestLabel: lowest, FifoBuckets: fifo}
+		pit, status := solver.computeSolution(nil, data, pre)
+		if status != 0 {
+			t.Fatalf("pseudoflow failed, status %v", status)
+		}
+		flow, _, ok := solver.maxFlow()
+		if !ok {
+			t.Fatal("pseudoflow has no max flow")
+		}
+		return pit, flow
+	}
+}
+
+func TestPseudoflow(t *testing.T) {
+	for _, v := range pseudoflowVariants {
+		t.Run(fmt.Sprintf("lowest %v fifo %v", v.lowest, v.fifo), func(t *testing.T) {
+			testClosureCases(t, pseudoflowSolver(t, v.lowest, v.fifo))
+		})
+	}
+}
+
+func TestPseudoflowNewman1(t *testing.T) {
+	for _, v := range pseudoflowVariants {
+		t.Run(fmt.Sprintf("lowest %v fifo %v", v.lowest, v.fifo), func(t *testing.T) {
+			testNewman1(t, pseudoflowSolver(t, v.lowest, v.fifo))
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package optimization
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// Goldberg and Tarjan's push-relabel on the closure graph, highest label
+// first as in Cherkassky and Goldberg's hi_pr. The network is the one the
+// Dimacs program is given: source to the positive blocks, the negative ones
+// to the sink and infinite arcs block to predecessor. The labels are the
+// distances to the sink, found again from time to time by a breadth first
+// search backwards (the global relabel), and the nodes above an empty label
+// can't reach the sink and are lifted to the top at once (the gap).
+//
+// The first phase leaves a preflow whose cut is minimum, the second pushes
+// the excess left back to the source. The pit is then the blocks the source
+// reaches, the smallest of the optimal ones, as the pseudoflow gives.
+
+const (
+	// Relabel work per node, and per arc, before a global relabel
+	PR_ALPHA = 6
+	PR_BETA  = 12
+)
+
+type (
+	// PushRelabel solves the closure graph as a max flow by push-relabel
+	PushRelabel struct {
+		Precision float64
+		// The max flow of the last solve, in capacity units
+		flow int64
+	}
+
+	pushRelabel struct {
+		numNodes int32
+		source   int32
+		sink     int32
+
+		// Arcs of every node, each with its reverse, and residual capacities
+		first []int32
+		head  []int32
+		rev   []int32
+		res   []int64
+
+		excess  []int64
+		label   []int32
+		current []int32
+
+		// Active nodes by label, and all nodes by label for the gaps
+		active     []int32
+		nextActive []int32
+		all        []int32
+		nextAll    []int32
+		prevAll    []int32
+		top        int32
+		maxLabel   int32
+
+		work     int
+		pushes   int
+		relabels int
+		globals  int
+		gaps     int
+	}
+)
+
+func newPushRelabelEngine(param *ConfigParams) UEngine {
+	return &PushRelabel{Precision: param.precision()}
+}
+
+func (solver *PushRelabel) computeSolution(ch chan<- string, data []float64, pre *Precedence) ([]bool, int) {
+
+	notifyStatus(ch, "Solving push-relabel")
+
+	pr := newPushRelabel(data, pre, solver.Precision)
+	pr.solve()
+
+	solver.flow = pr.excess[pr.sink]
+
+	return pr.sourceSet(), 0
+}
+
+func (solver *PushRelabel) maxFlow() (int64, float64, bool) {
+	return solver.flow, solver.Precision, true
+}
+
+// Build the closure graph of data and pre, the values times precision as
+// capacities. Blocks are nodes 0..n-1, then the source and the sink.
+func newPushRelabel(data []float64, pre *Precedence, precision float64) *pushRelabel {
+
+	n := int32(len(data))
+	numNodes := n + 2
+
+	pr := &pushRelabel{
+		numNodes: numNodes,
+		source:   n,
+		sink:     n + 1,
+		first:    make([]int32, numNodes+1),
+	}
+
+	// Every arc is counted at both ends
+	for i, v := range data {
+		if c := dimacsCapacity(v, precision); c > 0 {
+			pr.first[i+1]++
+			if v > 0 {
+				pr.first[pr.source+1]++
+			} else {
+				pr.first[pr.sink+1]++
+			}
+		}
+		if key := pre.keys[i]; key != MISSING {
+			for _, off := range pre.defs[key] {
+				pr.first[i+1]++
+				pr.first[i+off+1]++
+			}
+		}
+	}
+	for u := int32(0); u < numNodes; u++ {
+		pr.first[u+1] += pr.first[u]
+	}
+
+	m := pr.first[numNodes]
+	pr.head = make([]int32, m)
+	pr.rev = make([]int32, m)
+	pr.res = make([]int64, m)
+
+	fill := append([]int32(nil), pr.first[:numNodes]...)
+	add := func(u, v int32, c int64) {
+		a, b := fill[u], fill[v]
+		fill[u]++
+		fill[v]++
+		pr.head[a], pr.rev[a], pr.res[a] = v, b, c
+		pr.head[b], pr.rev[b], pr.res[b] = u, a, 0
+	}
+
+	inf := dimacsInfinity(data, precision)
+
+	for i, v := range data {
+		if c := dimacsCapacity(v, precision); c > 0 {
+			if v > 0 {
+				add(pr.source, int32(i), c)
+			} else {
+				add(int32(i), pr.sink, c)
+			}
+		}
+		if key := pre.keys[i]; key != MISSING {
+			for _, off := range pre.defs[key] {
+				add(int32(i), int32(i+off), inf)
+			}
+		}
+	}
+
+	pr.excess = make([]int64, numNodes)
+	pr.label = make([]int32, numNodes)
+	pr.current = make([]int32, numNodes)
+	pr.active = make([]int32, numNodes+1)
+	pr.nextActive = make([]int32, numNodes)
+	pr.all = make([]int32, numNodes+1)
+	pr.nextAll = make([]int32, numNodes)
+	pr.prevAll = make([]int32, numNodes)
+
+	return pr
+}
+
+// Saturate the source arcs, then push the excess to the sink and what can't
+// get there back to the source
+func (pr *pushRelabel) solve() {
+
+	s := pr.source
+	for a := pr.first[s]; a < pr.first[s+1]; a++ {
+		if c := pr.res[a]; c > 0 {
+			pr.res[a] = 0
+			pr.res[pr.rev[a]] += c
+			pr.excess[pr.head[a]] += c
+			pr.excess[s] -= c
+		}
+	}
+
+	pr.run(pr.sink)
+	log.Debugf("Push-relabel flow: %v", pr.excess[pr.sink])
+
+	pr.run(pr.source)
+
+	log.Debugf(
+		"Push-relabel pushes: %v, relabels: %v, global relabels: %v, gaps: %v",
+		pr.pushes, pr.relabels, pr.globals, pr.gaps,
+	)
+}
+
+// Discharge the active nodes, highest label first, towards target
+func (pr *pushRelabel) run(target int32) {
+
+	m := int(pr.first[pr.numNodes])
+	threshold := PR_ALPHA*int(pr.numNodes) + m/2
+
+	pr.globalRelabel(target)
+
+	for {
+		for pr.top >= 0 && pr.active[pr.top] == NOTHING {
+			pr.top--
+		}
+		if pr.top < 0 {
+			return
+		}
+
+		u := pr.active[pr.top]
+		pr.active[pr.top] = pr.nextActive[u]
+
+		// Nodes cut off by a gap stay in their list
+		if pr.label[u] != pr.top || pr.excess[u] <= 0 {
+			continue
+		}
+
+		pr.discharge(u)
+
+		if pr.work > threshold {
+			pr.globalRelabel(target)
+		}
+	}
+}
+
+// The labels by a breadth first search from target on the reversed residual
+// arcs, the nodes it doesn't reach at the top label. The other terminal is
+// left out.
+func (pr *pushRelabel) globalRelabel(target int32) {
+
+	n := pr.numNodes
+	pr.globals++
+	pr.work = 0
+
+	for l := int32(0); l <= n; l++ {
+		pr.active[l] = NOTHING
+		pr.all[l] = NOTHING
+	}
+	for u := int32(0); u < n; u++ {
+		pr.label[u] = n
+		pr.current[u] = pr.first[u]
+	}
+	pr.top, pr.maxLabel = -1, 0
+
+	pr.label[target] = 0
+	queue := []int32{target}
+
+	for k := 0; k < len(queue); k++ {
+		v := queue[k]
+		for a := pr.first[v]; a < pr.first[v+1]; a++ {
+			u := pr.head[a]
+			if pr.label[u] < n || u == pr.source || u == pr.sink || pr.res[pr.rev[a]] == 0 {
+				continue
+			}
+			pr.label[u] = pr.label[v] + 1
+			pr.addAll(u)
+			if pr.excess[u] > 0 {
+				pr.addActive(u)
+			}
+			queue = append(queue, u)
+		}
+	}
+}
+
+func (pr *pushRelabel) addActive(u int32) {
+	l := pr.label[u]
+	pr.nextActive[u] = pr.active[l]
+	pr.active[l] = u
+	if l > pr.top {
+		pr.top = l
+	}
+}
+
+func (pr *pushRelabel) addAll(u int32) {
+	l := pr.label[u]
+	pr.prevAll[u] = NOTHING
+	pr.nextAll[u] = pr.all[l]
+	if pr.all[l] != NOTHING {
+		pr.prevAll[pr.all[l]] = u
+	}
+	pr.all[l] = u
+	if l > pr.maxLabel {
+		pr.maxLabel = l
+	}
+}
+
+func (pr *pushRelabel) removeAll(u int32) {
+	if pr.prevAll[u] != NOTHING {
+		pr.nextAll[pr.prevAll[u]] = pr.nextAll[u]
+	} else {
+		pr.all[pr.label[u]] = pr.nextAll[u]
+	}
+	if pr.nextAll[u] != NOTHING {
+		pr.prevAll[pr.nextAll[u]] = pr.prevAll[u]
+	}
+}
+
+// Push the excess of u down admissible arcs, relabeling u when there are
+// none, until it has none left or it is cut off
+func (pr *pushRelabel) discharge(u int32) {
+
+	n := pr.numNodes
+
+	for pr.excess[u] > 0 {
+
+		for a := pr.current[u]; a < pr.first[u+1]; a++ {
+			v := pr.head[a]
+			if pr.res[a] == 0 || pr.label[v]+1 != pr.label[u] {
+				continue
+			}
+
+			delta := pr.excess[u]
+			if pr.res[a] < delta {
+				delta = pr.res[a]
+			}
+			pr.res[a] -= delta
+			pr.res[pr.rev[a]] += delta
+			pr.excess[u] -= delta
+			if pr.excess[v] == 0 && v != pr.source && v != pr.sink {
+				pr.addActive(v)
+			}
+			pr.excess[v] += delta
+			pr.pushes++
+
+			if pr.excess[u] == 0 {
+				pr.current[u] = a
+				return
+			}
+		}
+
+		if pr.relabel(u); pr.label[u] >= n {
+			return
+		}
+	}
+}
+
+// Move u to one above its lowest residual neighbour. If its label is left
+// empty, u and every node above are cut off from the target.
+func (pr *pushRelabel) relabel(u int32) {
+
+	n := pr.numNodes
+	old := pr.label[u]
+
+	pr.relabels++
+	pr.work += PR_BETA + int(pr.first[u+1]-pr.first[u])
+	pr.removeAll(u)
+
+	if pr.all[old] == NOTHING {
+		pr.gap(old)
+		pr.label[u] = n
+		return
+	}
+
+	l := n
+	for a := pr.first[u]; a < pr.first[u+1]; a++ {
+		if pr.res[a] > 0 && pr.label[pr.head[a]]+1 < l {
+			l = pr.label[pr.head[a]] + 1
+			pr.current[u] = a
+		}
+	}
+
+	pr.label[u] = l
+	if l < n {
+		pr.addAll(u)
+	}
+}
+
+// Lift the nodes above the empty label to the top
+func (pr *pushRelabel) gap(empty int32) {
+
+	pr.gaps++
+
+	for l := empty + 1; l <= pr.maxLabel; l++ {
+		for u := pr.all[l]; u != NOTHING; u = pr.nextAll[u] {
+			pr.label[u] = pr.numNodes
+		}
+		pr.all[l] = NOTHING
+	}
+
+	pr.maxLabel = empty - 1
+}
+
+// The blocks the source reaches in the residual graph
+func (pr *pushRelabel) sourceSet() []bool {
+
+	reached := make([]bool, pr.numNodes)
+	reached[pr.source] = true
+	queue := []int32{pr.source}
+
+	for k := 0; k < len(queue); k++ {
+		u := queue[k]
+		for a := pr.first[u]; a < pr.first[u+1]; a++ {
+			if v := pr.head[a]; pr.res[a] > 0 && !reached[v] {
+				reached[v] = true
+				queue = append(queue, v)
+			}
+		}
+	}
+
+	return reached[:pr.source]
+}
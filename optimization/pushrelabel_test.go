@@ -0,0 +1,23 @@
+package optimization
+
+import "testing"
+
+func pushRelabelSolver(t *testing.T) closureSolver {
+	return func(data []float64, pre *Precedence, precision float64) ([]bool, int64) {
+		solver := &PushRelabel{Precision: precision}
+		pit, status := solver.computeSolution(nil, data, pre)
+		if status != 0 {
+			t.Fatalf("push-relabel failed, status %v", status)
+		}
+		flow, _, _ := solver.maxFlow()
+		return pit, flow
+	}
+}
+
+func TestPushRelabel(t *testing.T) {
+	testClosureCases(t, pushRelabelSolver(t))
+}
+
+func TestPushRelabelNewman1(t *testing.T) {
+	testNewman1(t, pushRelabelSolver(t))
+}
@@ -0,0 +1,210 @@
+package optimization
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+
+	log "github.com/cihub/seelog"
+)
+
+type (
+	// ReportParams is loaded from json. The pit by pit report of a nested
+	// shells run, the rate in tonnes per year and the discount rate per year
+	// (0.1 is 10%).
+	ReportParams struct {
+		File         string  `json:"file"`
+		MiningRate   float64 `json:"mining_rate"`
+		DiscountRate float64 `json:"discount_rate"`
+	}
+
+	// PitRow is a line of the pit by pit report, the pit being the union of
+	// the shells up to Shell.
+	PitRow struct {
+		Realization int
+		Shell       int
+		Factor      float64
+		Tonnes      float64
+		OreTonnes   float64
+		WasteTonnes float64
+		Value       float64
+		BestCase    float64
+		WorstCase   float64
+	}
+
+	// A part of a pit mined as a whole
+	pitChunk struct {
+		tonnes float64
+		value  float64
+	}
+)
+
+// Is the report requested
+func (rp *ReportParams) enabled() bool {
+	return len(rp.File) > 0
+}
+
+func (rp *ReportParams) check() error {
+	if rp.MiningRate <= 0 {
+		return fmt.Errorf("ERROR: report mining_rate must be positive. Supplied: %v", rp.MiningRate)
+	} else if rp.DiscountRate < 0 {
+		return fmt.Errorf("ERROR: report discount_rate must not be negative. Supplied: %v", rp.DiscountRate)
+	}
+	return nil
+}
+
+// The discounted value of mining the chunks in order at the mining rate.
+// Cash flows are discounted at the end of the year they fall in.
+func (rp *ReportParams) npv(chunks []pitChunk) float64 {
+
+	var npv float64
+	year := 0
+	left := rp.MiningRate
+	factor := 1.0 / (1.0 + rp.DiscountRate)
+
+	for _, c := range chunks {
+
+		if c.tonnes <= 0 {
+			npv += c.value * factor
+			continue
+		}
+
+		for tonnes := c.tonnes; tonnes > 0; {
+			take := math.Min(tonnes, left)
+			npv += c.value * take / c.tonnes * factor
+
+			tonnes -= take
+			if left -= take; left <= 0 {
+				year++
+				left = rp.MiningRate
+				factor /= 1.0 + rp.DiscountRate
+			}
+		}
+	}
+
+	return npv
+}
+
+// The pit by pit report of one realization. shells and ebv are condensed,
+// blocks is the grid index of every condensed block.
+func (ctx *Parameters) pitByPit(r int, factors []float64, shells []int, blocks []int, ebv []float64) []PitRow {
+
+	nShells := len(factors)
+	nBench := ctx.Input.Grid.NumZ
+
+	// The chunks of every shell (1 indexed) and bench
+	chunks := make([][]pitChunk, nShells+1)
+	ore := make([]float64, nShells+1)
+	for s := range chunks {
+		chunks[s] = make([]pitChunk, nBench)
+	}
+
+	for i, s := range shells {
+		if s == NO_SHELL {
+			continue
+		}
+
+		k := blocks[i]
+		z := ctx.Input.Grid.gridIz(k)
+		t := ctx.Input.tonnage(k)
+
+		chunks[s][z].tonnes += t
+		chunks[s][z].value += ebv[i]
+
+		if ebv[i] > 0 {
+			ore[s] += t
+		}
+	}
+
+	rows := make([]PitRow, nShells)
+
+	var best []pitChunk
+	var row PitRow
+
+	for s := 1; s <= nShells; s++ {
+
+		row.Realization = r
+		row.Shell = s
+		row.Factor = factors[s-1]
+
+		// Best case, shell by shell from the top down
+		for z := nBench - 1; z >= 0; z-- {
+			c := chunks[s][z]
+			row.Tonnes += c.tonnes
+			row.Value += c.value
+			best = append(best, c)
+		}
+		row.OreTonnes += ore[s]
+		row.WasteTonnes = row.Tonnes - row.OreTonnes
+		row.BestCase = ctx.Report.npv(best)
+
+		// Worst case, the whole pit bench by bench
+		worst := make([]pitChunk, nBench)
+		for z := 0; z < nBench; z++ {
+			for t := 1; t <= s; t++ {
+				worst[nBench-1-z].tonnes += chunks[t][z].tonnes
+				worst[nBench-1-z].value += chunks[t][z].value
+			}
+		}
+		row.WorstCase = ctx.Report.npv(worst)
+
+		rows[s-1] = row
+	}
+
+	return rows
+}
+
+// Log the report and write it as csv
+func (rp *ReportParams) write(rows []PitRow) error {
+
+	log.Info("Pit by pit report")
+	log.Infof("  mining rate: %v t/year, discount rate: %v", rp.MiningRate, rp.DiscountRate)
+	log.Info("  real shell   factor       tonnes          ore        waste           value       best case      worst case")
+
+	for _, row := range rows {
+		log.Infof(
+			"  %4d %5d %8.4f %12.0f %12.0f %12.0f %15.2f %15.2f %15.2f",
+			row.Realization, row.Shell, row.Factor,
+			row.Tonnes, row.OreTonnes, row.WasteTonnes,
+			row.Value, row.BestCase, row.WorstCase,
+		)
+	}
+
+	file, e := os.Create(rp.File)
+	if e != nil {
+		e = fmt.Errorf("Failed to create report file %v: %v", rp.File, e)
+		log.Error(e)
+		return e
+	}
+
+	w := bufio.NewWriter(file)
+
+	fmt.Fprintln(w, "realization,shell,revenue_factor,tonnes,ore_tonnes,waste_tonnes,strip_ratio,value,best_case,worst_case")
+
+	for _, row := range rows {
+		// No strip ratio without ore
+		var strip string
+		if row.OreTonnes > 0 {
+			strip = fmt.Sprintf("%g", row.WasteTonnes/row.OreTonnes)
+		}
+		fmt.Fprintf(
+			w, "%d,%d,%g,%g,%g,%g,%v,%g,%g,%g\n",
+			row.Realization, row.Shell, row.Factor,
+			row.Tonnes, row.OreTonnes, row.WasteTonnes, strip,
+			row.Value, row.BestCase, row.WorstCase,
+		)
+	}
+
+	e = w.Flush()
+	if ce := file.Close(); e == nil {
+		e = ce
+	}
+
+	if e != nil {
+		e = fmt.Errorf("Failed to write report file %v: %v", rp.File, e)
+		log.Error(e)
+	}
+
+	return e
+}
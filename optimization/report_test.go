@@ -0,0 +1,48 @@
+package optimization
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportWrite(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "whattle-test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	rp := &ReportParams{File: filepath.Join(dir, "report.csv"), MiningRate: 1000}
+	rows := []PitRow{
+		{Realization: 0, Shell: 1, Factor: 0.5, Tonnes: 300, OreTonnes: 100, WasteTonnes: 200, Value: 10},
+		{Realization: 0, Shell: 2, Factor: 1, Tonnes: 50, WasteTonnes: 50, Value: -5},
+	}
+
+	if e := rp.write(rows); e != nil {
+		t.Fatal(e)
+	}
+
+	text, e := ioutil.ReadFile(rp.File)
+	if e != nil {
+		t.Fatal(e)
+	}
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+
+	want := []string{
+		"realization,shell,revenue_factor,tonnes,ore_tonnes,waste_tonnes,strip_ratio,value,best_case,worst_case",
+		"0,1,0.5,300,100,200,2,10,0,0",
+		"0,2,1,50,0,50,,-5,0,0",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("report:\n%v\nwant:\n%v", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	rp.File = filepath.Join(dir, "missing", "report.csv")
+	if e := rp.write(rows); e == nil {
+		t.Error("no error writing to a missing directory")
+	}
+}
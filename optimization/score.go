@@ -0,0 +1,80 @@
+package optimization
+
+import (
+	"fmt"
+
+	log "github.com/cihub/seelog"
+)
+
+const MAX_REPORTED = 10
+
+// Score an existing solution: check that it respects the precedence and
+// report its objective value for every realization.
+func Score(opt RunCtx) error {
+
+	log.Infof("Begin parsing parameters from %v", opt.ParamFile)
+
+	var params Parameters
+
+	if e := readJSONFile(opt.ParamFile, &params); e != nil {
+		return e
+	}
+
+	log.Infof("Begin reading input from %v", opt.InputFile)
+
+	if e := params.initialize(opt.InputFile); e != nil {
+		return e
+	}
+
+	// Every block needs its precedence, not only the candidates
+	n := params.Input.Grid.gridCount()
+	mask := make([]bool, n)
+	for i := range mask {
+		mask[i] = true
+	}
+
+	if e := params.Precedence.init(&params, mask); e != nil {
+		return e
+	}
+
+	log.Infof("Begin reading solution from %v", opt.SolutionFile)
+
+	selection, e := readMinelibSol(opt.SolutionFile, n)
+	if e != nil {
+		e = fmt.Errorf("Error: failed reading solution %v: %v", opt.SolutionFile, e)
+		log.Error(e)
+		return e
+	}
+
+	violations := params.Precedence.violations(selection)
+
+	for i, v := range violations {
+		if i == MAX_REPORTED {
+			log.Errorf("  ... and %v more", len(violations)-MAX_REPORTED)
+			break
+		}
+		log.Errorf("  block %v is mined without its predecessor %v", v[0], v[1])
+	}
+
+	for r, layer := range params.Input.Ebv {
+		ebv := float64(0)
+		count := int64(0)
+		for i, v := range selection {
+			if v {
+				ebv += layer[i]
+				count++
+			}
+		}
+		log.Infof("Score realization %3v. Blocks: %-6v, EBV: %f", r, count, ebv)
+	}
+
+	if len(violations) > 0 {
+		e = fmt.Errorf("ERROR: solution violates %v precedence arcs", len(violations))
+		log.Error(e)
+		return e
+	}
+
+	log.Info("Solution respects the precedence")
+
+	return nil
+}
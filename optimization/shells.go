@@ -0,0 +1,257 @@
+package optimization
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	log "github.com/cihub/seelog"
+)
+
+type (
+	// ShellParams is loaded from json. The revenue factors are either listed
+	// or generated from min_factor to max_factor by step.
+	ShellParams struct {
+		Factors   []float64 `json:"revenue_factors"`
+		MinFactor float64   `json:"min_factor"`
+		MaxFactor float64   `json:"max_factor"`
+		Step      float64   `json:"step"`
+	}
+)
+
+const (
+	MAX_SHELLS = 999
+	// Not in any shell
+	NO_SHELL = 0
+)
+
+// Are nested shells requested
+func (sp *ShellParams) enabled() bool {
+	return len(sp.Factors) > 0 || sp.Step > 0
+}
+
+// The revenue factors in increasing order
+func (sp *ShellParams) factors() ([]float64, error) {
+
+	var factors []float64
+
+	if len(sp.Factors) > 0 {
+		factors = append(factors, sp.Factors...)
+	} else if sp.Step <= 0 || sp.MinFactor <= 0 || sp.MaxFactor < sp.MinFactor {
+		return nil, fmt.Errorf(
+			"ERROR: shells need 0 < min_factor <= max_factor and step > 0. Supplied: %v, %v, %v",
+			sp.MinFactor, sp.MaxFactor, sp.Step,
+		)
+	} else {
+		n := int(math.Floor((sp.MaxFactor-sp.MinFactor)/sp.Step+1e-9)) + 1
+		if n > MAX_SHELLS {
+			return nil, fmt.Errorf("ERROR: number of shells must be at most %v. Supplied: %v", MAX_SHELLS, n)
+		}
+		for i := 0; i < n; i++ {
+			factors = append(factors, sp.MinFactor+float64(i)*sp.Step)
+		}
+	}
+
+	sort.Float64s(factors)
+
+	for i, f := range factors {
+		if f <= 0 {
+			return nil, fmt.Errorf("ERROR: revenue factors must be positive. Supplied: %v", f)
+		} else if i > 0 && f == factors[i-1] {
+			return nil, fmt.Errorf("ERROR: duplicate revenue factor %v", f)
+		}
+	}
+
+	if len(factors) > MAX_SHELLS {
+		return nil, fmt.Errorf("ERROR: number of shells must be at most %v. Supplied: %v", MAX_SHELLS, len(factors))
+	}
+
+	return factors, nil
+}
+
+// The value of a block at a revenue factor. Only the EBV is known, so the
+// factor is applied to the positive (revenue generating) blocks while the
+// waste keeps its cost.
+func (sp *ShellParams) scale(v, factor float64) float64 {
+	if v > 0 {
+		return v * factor
+	}
+	return v
+}
+
+// Nested pit shells. Every realization is solved at each revenue factor and
+// every block gets the number (1 indexed, from the lowest factor) of the
+// smallest shell it is in, 0 if in none.
+//
+// The pit of a lower factor is contained in the pit of a higher one, so the
+// factors are solved from the highest down, each restricted to the previous
+// pit. Every solve is then smaller than the one before.
+func (ctx *Parameters) NestedShells(ch chan<- string) ([][]int, []float64, int) {
+
+	factors, e := ctx.Shells.factors()
+	if e == nil && ctx.Report.enabled() {
+		e = ctx.Report.check()
+	}
+	if e != nil {
+		log.Error(e)
+		return nil, nil, -1
+	}
+
+	nReal := len(ctx.Input.Ebv)
+	nData := len(ctx.Input.Ebv[0])
+
+	log.Infof("Number of realizations: %v", nReal)
+	log.Infof("Number of rows: %v", nData)
+	log.Infof("Number of shells: %v, revenue factors %v to %v", len(factors), factors[0], factors[len(factors)-1])
+
+	mask, condensedEBV, condensedPre, status := ctx.condense(ch)
+	if status != 0 {
+		return nil, nil, status
+	}
+
+	count := len(condensedEBV.Ebv[0])
+	solutions := make([][]int, nReal)
+
+	// The block of every condensed index
+	blocks := make([]int, 0, count)
+	for i, v := range mask {
+		if v {
+			blocks = append(blocks, i)
+		}
+	}
+
+	var report []PitRow
+
+	//--------------------------------------------------
+	// Solve-em
+
+	log.Info("Begin optimizing shells")
+	notifyStatus(ch, "Optimizing shells")
+
+	for r := 0; r < nReal; r++ {
+
+		shells := make([]int, count)
+
+		// The blocks of the current subproblem, as condensed indexes
+		subset := make([]int, count)
+		for i := range subset {
+			subset[i] = i
+		}
+		subPre := condensedPre
+
+		for k := len(factors) - 1; k >= 0 && len(subset) > 0; k-- {
+
+			data := make([]float64, len(subset))
+			for i, j := range subset {
+				data[i] = ctx.Shells.scale(condensedEBV.Ebv[r][j], factors[k])
+			}
+
+			data = condensedEBV.engineValues(data, subset)
+
+			name := fmt.Sprintf("realization %v, shell %v", r, k+1)
+			pit, status := ctx.solve(ch, name, data, subPre, func(i int) int { return blocks[subset[i]] })
+			if status != 0 {
+				return nil, nil, status
+			}
+
+			var next []int
+			var ebv float64
+			for i, v := range pit {
+				if v {
+					shells[subset[i]] = k + 1
+					next = append(next, subset[i])
+					ebv += condensedEBV.Ebv[r][subset[i]]
+				}
+			}
+
+			log.Infof("Realization %3v, shell %3v (factor %.4f). Blocks: %-6v, EBV: %f", r, k+1, factors[k], len(next), ebv)
+
+			// Restrict the precedence to the pit, which is closed
+			if len(next) > 0 && len(next) < len(subset) {
+				nextPre := new(Precedence)
+				compressPrecedence(pit, len(next), subPre, nextPre)
+				subPre = nextPre
+			}
+			subset = next
+		}
+
+		solutions[r] = shells
+
+		if ctx.Report.enabled() {
+			report = append(report, ctx.pitByPit(r, factors, shells, blocks, condensedEBV.Ebv[r])...)
+		}
+
+		progress := fmt.Sprintf(
+			"Solved %v/%v(%.3f%%)",
+			(r + 1), nReal, 100.0*float64(r+1)/float64(nReal),
+		)
+		notifyStatus(ch, progress)
+	}
+
+	if ctx.Report.enabled() {
+		if e = ctx.Report.write(report); e != nil {
+			return nil, nil, 1
+		}
+	}
+
+	//--------------------------------------------------
+	// Expand the solutions out
+
+	log.Info("Decompressing shells")
+	notifyStatus(ch, "Decompressing")
+
+	result := make([][]int, nReal)
+	for i := range result {
+		result[i] = make([]int, nData)
+	}
+
+	j := 0
+	for i := 0; i < nData; i++ {
+		if mask[i] {
+			for r := 0; r < nReal; r++ {
+				result[r][i] = solutions[r][j]
+			}
+			j++
+		}
+	}
+
+	// Fix air blocks, a predecessor is in the same shell or a smaller one
+	log.Info("Fixing air blocks")
+	notifyStatus(ch, "Fixing air blocks")
+	for r := 0; r < nReal; r++ {
+		ctx.Precedence.shellClosure(result[r])
+		for i := range result[r] {
+			if ctx.Input.isAir(i) {
+				result[r][i] = NO_SHELL
+			}
+		}
+	}
+
+	return result, factors, 0
+}
+
+// Put every block in the smallest shell of the blocks depending on it,
+// following the predecessors until none changes.
+func (prec *Precedence) shellClosure(shells []int) {
+
+	var stack IntStack
+
+	for i, s := range shells {
+		if s != NO_SHELL {
+			stack.push(i)
+		}
+	}
+
+	for stack.notEmpty() {
+		i := stack.pop()
+		if key := prec.keys[i]; key != MISSING {
+			s := shells[i]
+			for _, off := range prec.defs[key] {
+				if j := i + off; shells[j] == NO_SHELL || shells[j] > s {
+					shells[j] = s
+					stack.push(j)
+				}
+			}
+		}
+	}
+}
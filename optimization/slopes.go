@@ -0,0 +1,196 @@
+package optimization
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	log "github.com/cihub/seelog"
+)
+
+type (
+	// SlopeSector is the overall slope (degrees) of the wall facing an
+	// azimuth (degrees clockwise from north)
+	SlopeSector struct {
+		Azimuth float64 `json:"azimuth"`
+		Slope   float64 `json:"slope"`
+	}
+
+	// SlopeSectors is a slope table, interpolated linearly between sectors
+	SlopeSectors []SlopeSector
+
+	// SlopeZone is the slope, or slope sectors, of a geotechnical zone
+	SlopeZone struct {
+		Zone    int          `json:"zone"`
+		Slope   float64      `json:"slope"`
+		Sectors SlopeSectors `json:"sectors"`
+	}
+)
+
+func (sectors SlopeSectors) check() error {
+
+	if len(sectors) == 0 {
+		return fmt.Errorf("ERROR: at least one slope sector is needed")
+	}
+
+	for _, sec := range sectors {
+		if sec.Azimuth < 0 || sec.Azimuth >= 360 {
+			return fmt.Errorf("ERROR: sector azimuth must be between 0 and 360. Supplied: %v", sec.Azimuth)
+		} else if sec.Slope < MIN_SLOPE || sec.Slope > MAX_SLOPE {
+			return fmt.Errorf(
+				"ERROR: slope must be between %v and %v. Supplied: %v",
+				MIN_SLOPE, MAX_SLOPE, sec.Slope,
+			)
+		}
+	}
+
+	sort.Slice(sectors, func(i, j int) bool { return sectors[i].Azimuth < sectors[j].Azimuth })
+
+	for i := 1; i < len(sectors); i++ {
+		if sectors[i].Azimuth == sectors[i-1].Azimuth {
+			return fmt.Errorf("ERROR: duplicate sector azimuth %v", sectors[i].Azimuth)
+		}
+	}
+
+	return nil
+}
+
+// The lowest slope of the table
+func (sectors SlopeSectors) flattest() float64 {
+	slope := math.Inf(1)
+	for _, sec := range sectors {
+		slope = math.Min(slope, sec.Slope)
+	}
+	return slope
+}
+
+// The slope at an azimuth, interpolated between the sectors either side of
+// it. The sectors must be checked (sorted) first.
+func (sectors SlopeSectors) slopeAt(azimuth float64) float64 {
+
+	n := len(sectors)
+	if n == 1 {
+		return sectors[0].Slope
+	}
+
+	// The first sector past the azimuth, wrapping around north
+	i := sort.Search(n, func(i int) bool { return sectors[i].Azimuth > azimuth })
+
+	lo, hi := sectors[(i+n-1)%n], sectors[i%n]
+
+	span := hi.Azimuth - lo.Azimuth
+	if span <= 0 {
+		span += 360.0
+	}
+	dist := azimuth - lo.Azimuth
+	if dist < 0 {
+		dist += 360.0
+	}
+
+	return lo.Slope + (hi.Slope-lo.Slope)*dist/span
+}
+
+func (prec *Precedence) checkSectors() error {
+	if prec.NumBenches < MIN_BENCHES || prec.NumBenches > MAX_BENCHES {
+		return fmt.Errorf(
+			"ERROR: benches must be between %v and %v. Supplied: %v",
+			MIN_BENCHES, MAX_BENCHES, prec.NumBenches,
+		)
+	}
+	return prec.Sectors.check()
+}
+
+// Like genBench, with the slope of the cone varying with the azimuth
+func (prec *Precedence) genSectors(ctx *Parameters, mask []bool) {
+
+	pg := &ctx.Input.Grid
+
+	tmpl := prec.benchTemplate(pg, prec.Sectors.flattest(), prec.Sectors.slopeAt)
+	prec.applyTemplate(pg, mask, tmpl)
+}
+
+// A single slope as a table
+func constantSlope(slope float64) SlopeSectors {
+	return SlopeSectors{{Azimuth: 0, Slope: slope}}
+}
+
+// The slope table of a zone
+func (zone *SlopeZone) table() SlopeSectors {
+	if len(zone.Sectors) > 0 {
+		return zone.Sectors
+	}
+	return constantSlope(zone.Slope)
+}
+
+// The slope table of blocks with no zone of their own
+func (prec *Precedence) defaultTable() SlopeSectors {
+	if len(prec.Sectors) > 0 {
+		return prec.Sectors
+	}
+	return constantSlope(prec.Slope)
+}
+
+func (prec *Precedence) checkZones(ctx *Parameters) error {
+
+	if prec.NumBenches < MIN_BENCHES || prec.NumBenches > MAX_BENCHES {
+		return fmt.Errorf(
+			"ERROR: benches must be between %v and %v. Supplied: %v",
+			MIN_BENCHES, MAX_BENCHES, prec.NumBenches,
+		)
+	} else if ctx.Input.zones == nil {
+		return fmt.Errorf("ERROR: zone precedence requires an input zone_column")
+	} else if len(prec.Zones) == 0 {
+		return fmt.Errorf("ERROR: zone precedence requires zones")
+	}
+
+	seen := make(map[int]bool)
+
+	for i := range prec.Zones {
+		zone := &prec.Zones[i]
+		if seen[zone.Zone] {
+			return fmt.Errorf("ERROR: duplicate zone %v", zone.Zone)
+		}
+		seen[zone.Zone] = true
+
+		if e := zone.table().check(); e != nil {
+			return fmt.Errorf("%v (zone %v)", e, zone.Zone)
+		}
+	}
+
+	if e := prec.defaultTable().check(); e != nil {
+		return fmt.Errorf("%v (blocks without a zone)", e)
+	}
+
+	return nil
+}
+
+// A template for every zone, plus the default one. A block's wall starts in
+// the block right above it, so the zone of that block picks the template.
+func (prec *Precedence) genZones(ctx *Parameters, mask []bool) {
+
+	pg := &ctx.Input.Grid
+	zones := ctx.Input.zones
+
+	tmpls := make([]Template, len(prec.Zones)+1)
+	index := make(map[int]int)
+
+	for i := range prec.Zones {
+		log.Infof("Zone %v", prec.Zones[i].Zone)
+		table := prec.Zones[i].table()
+		tmpls[i] = prec.benchTemplate(pg, table.flattest(), table.slopeAt)
+		index[prec.Zones[i].Zone] = i
+	}
+
+	log.Info("Blocks without a zone")
+	table := prec.defaultTable()
+	tmpls[len(prec.Zones)] = prec.benchTemplate(pg, table.flattest(), table.slopeAt)
+
+	above := pg.NumX * pg.NumY
+
+	prec.applyTemplates(pg, mask, tmpls, func(loc int) int {
+		if i, ok := index[zones[loc+above]]; ok {
+			return i
+		}
+		return len(prec.Zones)
+	})
+}
@@ -0,0 +1,385 @@
+package optimization
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// Surfaces set the air explicitly: the topography, and the mined out
+// surface of the pits and voids already dug. A block whose centroid is above
+// either is air, it is worth nothing and is never a candidate, though the
+// walls may still go through it. Surfaces are read as ESRI ASCII grids
+// (.asc) or as gridded x y z points, plain or gzipped.
+
+type (
+	// Surface is an elevation grid, by cell centres from the south west
+	Surface struct {
+		NumX, NumY int
+		MinX, MinY float64
+		SizX, SizY float64
+		Z          []float64
+	}
+)
+
+// The elevation at x, y interpolated between the cell centres, NaN outside
+// the surface or where it has no data
+func (surf *Surface) elevation(x, y float64) float64 {
+
+	fx := (x - surf.MinX) / surf.SizX
+	fy := (y - surf.MinY) / surf.SizY
+
+	if fx < -0.5 || fy < -0.5 || fx > float64(surf.NumX)-0.5 || fy > float64(surf.NumY)-0.5 {
+		return math.NaN()
+	}
+
+	// Clamp to the cell centres on the edges
+	fx = math.Max(0, math.Min(fx, float64(surf.NumX-1)))
+	fy = math.Max(0, math.Min(fy, float64(surf.NumY-1)))
+
+	ix := int(math.Min(math.Floor(fx), float64(surf.NumX-2)))
+	iy := int(math.Min(math.Floor(fy), float64(surf.NumY-2)))
+	if ix < 0 {
+		ix = 0
+	}
+	if iy < 0 {
+		iy = 0
+	}
+
+	var z, w float64
+
+	for dy := 0; dy <= 1 && iy+dy < surf.NumY; dy++ {
+		for dx := 0; dx <= 1 && ix+dx < surf.NumX; dx++ {
+			v := surf.Z[(iy+dy)*surf.NumX+ix+dx]
+			if math.IsNaN(v) {
+				continue
+			}
+			wx := 1 - math.Abs(fx-float64(ix+dx))
+			wy := 1 - math.Abs(fy-float64(iy+dy))
+			z += v * wx * wy
+			w += wx * wy
+		}
+	}
+
+	if w <= 0 {
+		return math.NaN()
+	}
+
+	return z / w
+}
+
+// Read a surface, by its extension
+func readSurface(infile string) (*Surface, error) {
+
+	r, e := openInput(infile)
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+
+	if strings.EqualFold(filepath.Ext(strings.TrimSuffix(infile, ".gz")), ".asc") {
+		return readEsriAscii(r)
+	}
+	return readXyz(r)
+}
+
+// Read an ESRI ASCII grid, the header then the rows from the north
+func readEsriAscii(r io.Reader) (*Surface, error) {
+
+	surf := &Surface{}
+	nodata := math.NaN()
+	center := false
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+
+	var values []float64
+
+	for s.Scan() {
+		line++
+
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if _, e := strconv.ParseFloat(fields[0], 64); e != nil && len(values) == 0 {
+
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %v: expected keyword and value", line)
+			}
+
+			v, e := strconv.ParseFloat(fields[1], 64)
+			if e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			}
+
+			switch strings.ToLower(fields[0]) {
+			case "ncols":
+				surf.NumX = int(v)
+			case "nrows":
+				surf.NumY = int(v)
+			case "xllcorner":
+				surf.MinX = v
+			case "yllcorner":
+				surf.MinY = v
+			case "xllcenter":
+				surf.MinX, center = v, true
+			case "yllcenter":
+				surf.MinY, center = v, true
+			case "cellsize":
+				surf.SizX, surf.SizY = v, v
+			case "nodata_value":
+				nodata = v
+			default:
+				return nil, fmt.Errorf("line %v: unknown keyword %q", line, fields[0])
+			}
+			continue
+		}
+
+		if surf.NumX < 1 || surf.NumY < 1 || surf.SizX <= 0 {
+			return nil, fmt.Errorf("line %v: ncols, nrows and cellsize are needed before the values", line)
+		}
+
+		for _, f := range fields {
+			v, e := strconv.ParseFloat(f, 64)
+			if e != nil {
+				return nil, fmt.Errorf("line %v: %v", line, e)
+			}
+			if v == nodata {
+				v = math.NaN()
+			}
+			values = append(values, v)
+		}
+	}
+
+	if e := s.Err(); e != nil {
+		return nil, e
+	} else if len(values) != surf.NumX*surf.NumY {
+		return nil, fmt.Errorf("expected %v values, found %v", surf.NumX*surf.NumY, len(values))
+	}
+
+	if !center {
+		surf.MinX += surf.SizX / 2.0
+		surf.MinY += surf.SizY / 2.0
+	}
+
+	// Rows from the south
+	surf.Z = make([]float64, len(values))
+	for y := 0; y < surf.NumY; y++ {
+		copy(surf.Z[y*surf.NumX:(y+1)*surf.NumX], values[(surf.NumY-1-y)*surf.NumX:])
+	}
+
+	return surf, nil
+}
+
+// Read x y z points on a regular grid, separated by spaces or commas. Lines
+// that are not numeric, a header, are skipped. Missing points have no data.
+func readXyz(r io.Reader) (*Surface, error) {
+
+	var points [][3]float64
+
+	s := bufio.NewScanner(r)
+	line := 0
+
+	for s.Scan() {
+		line++
+
+		fields := strings.FieldsFunc(s.Text(), func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\t' || c == ';'
+		})
+		if len(fields) < 3 {
+			continue
+		}
+
+		var p [3]float64
+		var e error
+		for i := range p {
+			if p[i], e = strconv.ParseFloat(fields[i], 64); e != nil {
+				break
+			}
+		}
+		if e != nil {
+			if len(points) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %v: %v", line, e)
+		}
+
+		points = append(points, p)
+	}
+
+	if e := s.Err(); e != nil {
+		return nil, e
+	} else if len(points) == 0 {
+		return nil, fmt.Errorf("no points")
+	}
+
+	// The grid, from the distinct coordinates
+	axis := func(k int) (lo, siz float64, n int, e error) {
+		var vs []float64
+		for _, p := range points {
+			vs = append(vs, p[k])
+		}
+		sort.Float64s(vs)
+
+		lo, siz = vs[0], math.Inf(1)
+		for i := 1; i < len(vs); i++ {
+			if d := vs[i] - vs[i-1]; d > 1e-6 && d < siz {
+				siz = d
+			}
+		}
+		if math.IsInf(siz, 1) {
+			return lo, 1, 1, nil
+		}
+
+		n = int(math.Round((vs[len(vs)-1]-lo)/siz)) + 1
+		for _, v := range vs {
+			if f := (v - lo) / siz; math.Abs(f-math.Round(f)) > 1e-3 {
+				return 0, 0, 0, fmt.Errorf("points are not on a regular grid, %v is off the %v spacing", v, siz)
+			}
+		}
+		return lo, siz, n, nil
+	}
+
+	surf := &Surface{}
+	var e error
+
+	if surf.MinX, surf.SizX, surf.NumX, e = axis(0); e != nil {
+		return nil, e
+	} else if surf.MinY, surf.SizY, surf.NumY, e = axis(1); e != nil {
+		return nil, e
+	}
+
+	surf.Z = make([]float64, surf.NumX*surf.NumY)
+	for i := range surf.Z {
+		surf.Z[i] = math.NaN()
+	}
+	for _, p := range points {
+		ix := int(math.Round((p[0] - surf.MinX) / surf.SizX))
+		iy := int(math.Round((p[1] - surf.MinY) / surf.SizY))
+		surf.Z[iy*surf.NumX+ix] = p[2]
+	}
+
+	return surf, nil
+}
+
+//---------------------------------------------------------------------------
+
+// Read the topography and the mined out surface, if given
+func (block *Data) readSurfaces() error {
+
+	for _, surf := range []struct {
+		file string
+		dest **Surface
+	}{
+		{block.Topography, &block.topoSurf},
+		{block.MinedOut, &block.minedSurf},
+	} {
+		if len(surf.file) == 0 {
+			continue
+		}
+		s, e := readSurface(surf.file)
+		if e != nil {
+			e = fmt.Errorf("Error: failed reading surface %v: %v", surf.file, e)
+			log.Error(e)
+			return e
+		}
+		log.Infof("Read surface %v: %v x %v cells", surf.file, s.NumX, s.NumY)
+		*surf.dest = s
+	}
+
+	return nil
+}
+
+// The topography above column k of the grid, NaN if unknown
+func (block *Data) topographyAt(k int) float64 {
+	if block.topoSurf == nil {
+		return math.NaN()
+	}
+	pg := &block.Grid
+	return block.topoSurf.elevation(pg.MinX+float64(pg.gridIx(k))*pg.SizX, pg.MinY+float64(pg.gridIy(k))*pg.SizY)
+}
+
+// Mark the blocks above the topography or the mined out surface as air and
+// zero their value
+func (block *Data) initializeAir() error {
+
+	if block.topoSurf == nil && block.minedSurf == nil {
+		return nil
+	}
+
+	pg := &block.Grid
+
+	block.air = make([]bool, pg.gridCount())
+
+	var count, valued, outside int
+	columns := pg.NumX * pg.NumY
+
+	for k := 0; k < columns; k++ {
+
+		x := pg.MinX + float64(pg.gridIx(k))*pg.SizX
+		y := pg.MinY + float64(pg.gridIy(k))*pg.SizY
+
+		// The lowest of the surfaces
+		top := math.Inf(1)
+		for _, surf := range []*Surface{block.topoSurf, block.minedSurf} {
+			if surf == nil {
+				continue
+			}
+			if z := surf.elevation(x, y); !math.IsNaN(z) {
+				top = math.Min(top, z)
+			}
+		}
+		if math.IsInf(top, 1) {
+			outside++
+			continue
+		}
+
+		for iz := pg.NumZ - 1; iz >= 0 && pg.benchElevation(iz) > top; iz-- {
+			i := k + iz*columns
+			block.air[i] = true
+			count++
+			for r := range block.Ebv {
+				if block.Ebv[r][i] != 0 {
+					block.Ebv[r][i] = 0
+					valued++
+				}
+			}
+		}
+	}
+
+	log.Infof("Air blocks: %v", count)
+	if valued > 0 {
+		log.Infof("  values zeroed in the air: %v", valued)
+	}
+	if outside > 0 {
+		log.Warnf("Columns with no surface elevation: %v", outside)
+	}
+
+	return nil
+}
+
+// Is block i explicitly air
+func (block *Data) isAir(i int) bool {
+	return block.air != nil && block.air[i]
+}
+
+// Air is not mined, whatever the pit above it
+func (block *Data) clearAir(selection []bool) {
+	if block.air != nil {
+		for i, v := range block.air {
+			if v {
+				selection[i] = false
+			}
+		}
+	}
+}
@@ -19,18 +19,3 @@ func readJSONFile(file string, ptr interface{}) error {
 		return nil
 	}
 }
-
-func sliceEqual(x, y []int) bool {
-	if len(x) == 0 && len(y) == 0 {
-		return true
-	} else if len(x) != len(y) {
-		return false
-	} else {
-		for i, a := range x {
-			if y[i] != a {
-				return false
-			}
-		}
-		return true
-	}
-}
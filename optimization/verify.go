@@ -0,0 +1,86 @@
+package optimization
+
+import (
+	"fmt"
+
+	log "github.com/cihub/seelog"
+)
+
+// The pits of the engines are checked when verify is set. A pit must be
+// closed, every predecessor of its blocks in it, and for the max flow
+// engines the capacity of its cut must be the max flow. A flow is never
+// more than a cut, so the two being equal proves the pit optimal.
+
+const (
+	// The most offending blocks logged
+	VERIFY_MAX_REPORT = 10
+)
+
+type (
+	// flowEngine knows the max flow of its last solve, in capacity units,
+	// and the precision of the capacities
+	flowEngine interface {
+		maxFlow() (int64, float64, bool)
+	}
+)
+
+// Check the pit of a condensed problem, block giving the grid index of its
+// blocks for the report
+func (ctx *Parameters) verify(engine UEngine, name string, data []float64, pre *Precedence, pit []bool, block func(i int) int) error {
+
+	pg := &ctx.Input.Grid
+	where := func(i int) string {
+		return pg.gridName(block(i))
+	}
+
+	var open int
+	for i, v := range pit {
+		if !v || pre.keys[i] == MISSING {
+			continue
+		}
+		for _, off := range pre.defs[pre.keys[i]] {
+			if !pit[i+off] {
+				if open < VERIFY_MAX_REPORT {
+					log.Errorf("  %v: block %v is in the pit, its predecessor %v is not", name, where(i), where(i+off))
+				}
+				open++
+			}
+		}
+	}
+
+	if open > 0 {
+		e := fmt.Errorf("ERROR: %v: the pit is not closed, %v predecessors missing", name, open)
+		log.Error(e)
+		return e
+	}
+
+	fe, ok := engine.(flowEngine)
+	if !ok {
+		log.Infof("Verified %v: the pit is closed", name)
+		return nil
+	}
+
+	flow, precision, ok := fe.maxFlow()
+	if !ok {
+		log.Infof("Verified %v: the pit is closed, no flow to certify it", name)
+		return nil
+	}
+
+	// The positive blocks left out and the negative ones mined
+	var cut int64
+	for i, v := range data {
+		if pit[i] == (v < 0) {
+			cut += dimacsCapacity(v, precision)
+		}
+	}
+
+	if cut != flow {
+		e := fmt.Errorf("ERROR: %v: the cut capacity %v is not the max flow %v", name, cut, flow)
+		log.Error(e)
+		return e
+	}
+
+	log.Infof("Verified %v: the pit is closed, its cut is the max flow %v", name, flow)
+
+	return nil
+}